@@ -0,0 +1,62 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// queryTypeAnnotation is the query type of annotation queries.
+const queryTypeAnnotation = "annotation"
+
+// annotationFrame shapes the result of an annotation query into a frame with
+// time, timeEnd, text and tags fields. Columns are matched by name, ignoring
+// case; only time is required.
+func annotationFrame(frame *data.Frame) (*data.Frame, error) {
+	timeField := fieldByName(frame, "time")
+	if timeField == nil {
+		return nil, fmt.Errorf("annotation query must return a time column")
+	}
+
+	times, err := timeValues(timeField)
+	if err != nil {
+		return nil, err
+	}
+
+	annotations := data.NewFrame("annotations", data.NewField("time", nil, times))
+
+	if field := fieldByName(frame, "timeEnd"); field != nil {
+		timeEnds, err := timeValues(field)
+		if err != nil {
+			return nil, err
+		}
+		annotations.Fields = append(annotations.Fields, data.NewField("timeEnd", nil, timeEnds))
+	}
+
+	for _, name := range []string{"text", "tags"} {
+		if field := fieldByName(frame, name); field != nil {
+			annotations.Fields = append(annotations.Fields, data.NewField(name, nil, stringValues(field)))
+		}
+	}
+
+	return annotations, nil
+}
+
+// fieldByName returns the field of frame named name, ignoring case.
+func fieldByName(frame *data.Frame, name string) *data.Field {
+	for _, field := range frame.Fields {
+		if strings.EqualFold(field.Name, name) {
+			return field
+		}
+	}
+	return nil
+}
+
+func stringValues(field *data.Field) []string {
+	values := make([]string, field.Len())
+	for i := range values {
+		values[i] = fieldValueString(field, i)
+	}
+	return values
+}
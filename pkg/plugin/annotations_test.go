@@ -0,0 +1,57 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestAnnotationFrame(t *testing.T) {
+	t.Run("epoch seconds and extra columns", func(t *testing.T) {
+		frame := data.NewFrame("response",
+			data.NewField("TIME", nil, []int64{1700000000}),
+			data.NewField("text", nil, []string{"large transfer"}),
+			data.NewField("value", nil, []float64{1.5}),
+			data.NewField("tags", nil, []string{"eth,transfer"}))
+
+		annotations, err := annotationFrame(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(annotations.Fields) != 3 {
+			t.Fatalf("wrong number of fields, %v", len(annotations.Fields))
+		}
+
+		if annotations.Fields[0].Name != "time" || annotations.Fields[0].Type() != data.FieldTypeTime {
+			t.Fatal("time must be the first field")
+		}
+
+		if annotations.Fields[0].At(0).(time.Time) != time.Unix(1700000000, 0).UTC() {
+			t.Fatalf("wrong time, %v", annotations.Fields[0].At(0))
+		}
+
+		if annotations.Fields[2].Name != "tags" || annotations.Fields[2].At(0) != "eth,transfer" {
+			t.Fatal("wrong tags")
+		}
+	})
+
+	t.Run("missing time column", func(t *testing.T) {
+		frame := data.NewFrame("response", data.NewField("text", nil, []string{"reorg"}))
+
+		if _, err := annotationFrame(frame); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
+
+func TestEpochToTime(t *testing.T) {
+	expected := time.Unix(1700000000, 0).UTC()
+
+	for _, v := range []int64{1700000000, 1700000000000, 1700000000000000, 1700000000000000000} {
+		if epochToTime(v) != expected {
+			t.Fatalf("wrong time for %v, %v", v, epochToTime(v))
+		}
+	}
+}
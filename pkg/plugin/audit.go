@@ -0,0 +1,350 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Audit sinks
+const (
+	auditSinkStdout  = "stdout"
+	auditSinkFile    = "file"
+	auditSinkWebhook = "webhook"
+)
+
+const (
+	auditWebhookTimeout      = 10 * time.Second
+	auditWebhookQueueSize    = 1000
+	auditWebhookDrainTimeout = 30 * time.Second
+)
+
+// auditLogDirEnv is the environment variable holding the directory audit log
+// files may be written to. It is set by the Grafana server administrator,
+// for instance as audit_log_dir in the plugin section of grafana.ini, so
+// datasource editors cannot write files anywhere else.
+const auditLogDirEnv = "GF_PLUGIN_AUDIT_LOG_DIR"
+
+var sqlTableReference = regexp.MustCompile(`(?i)\b(?:from|join)\s+((?:"[^"]+"|[\w]+)(?:\.(?:"[^"]+"|[\w]+))*)`)
+
+// auditRecord is a single line of the audit log, describing a query run by
+// a Grafana user.
+type auditRecord struct {
+	Timestamp     time.Time `json:"timestamp"`
+	OrgID         int64     `json:"orgId"`
+	User          string    `json:"user,omitempty"`
+	Email         string    `json:"email,omitempty"`
+	DatasourceUID string    `json:"datasourceUid"`
+	RefID         string    `json:"refId,omitempty"`
+	QuerySource   string    `json:"querySource"`
+	SQL           string    `json:"sql"`
+	Datasets      []string  `json:"datasets"`
+	Rows          int64     `json:"rows"`
+	Status        string    `json:"status"`
+	DurationMs    int64     `json:"durationMs"`
+}
+
+// auditSink writes audit log lines.
+type auditSink interface {
+	write(line []byte) error
+	Close() error
+}
+
+// auditor writes a sample of the executed queries to the audit sink. A nil
+// auditor records nothing.
+type auditor struct {
+	sink       auditSink
+	sampleRate float64
+	sample     func() float64
+}
+
+// newAuditor creates the auditor configured in the datasource settings, or
+// returns nil when auditing is disabled.
+func newAuditor(s datasourceSettings) (*auditor, error) {
+	var sink auditSink
+
+	switch s.AuditSink {
+	case "":
+		return nil, nil
+	case auditSinkStdout:
+		sink = &writerSink{w: os.Stdout}
+	case auditSinkFile:
+		path, err := auditLogPath(s.AuditPath, os.Getenv(auditLogDirEnv))
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("open audit log: %w", err)
+		}
+		sink = &writerSink{w: f, closer: f}
+	case auditSinkWebhook:
+		if s.AuditWebhookURL == "" {
+			return nil, fmt.Errorf("missing audit webhook URL")
+		}
+		sink = newWebhookSink(s.AuditWebhookURL, &http.Client{Timeout: auditWebhookTimeout})
+	default:
+		return nil, fmt.Errorf("unknown audit sink %q", s.AuditSink)
+	}
+
+	sampleRate := 1.0
+	if s.AuditSampleRate != nil && *s.AuditSampleRate >= 0 && *s.AuditSampleRate <= 1 {
+		sampleRate = *s.AuditSampleRate
+	}
+
+	return &auditor{sink: sink, sampleRate: sampleRate, sample: rand.Float64}, nil
+}
+
+// auditLogPath validates the audit log path configured in the datasource
+// settings: an absolute path, without parent references, inside the audit
+// log directory dir.
+func auditLogPath(path string, dir string) (string, error) {
+	if dir == "" {
+		return "", fmt.Errorf("file audit log is disabled, %s is not set", auditLogDirEnv)
+	}
+
+	if !filepath.IsAbs(dir) {
+		return "", fmt.Errorf("%s must be an absolute path", auditLogDirEnv)
+	}
+
+	if path == "" {
+		return "", fmt.Errorf("missing audit log path")
+	}
+
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("audit log path %q must be absolute", path)
+	}
+
+	for _, elem := range strings.Split(filepath.ToSlash(path), "/") {
+		if elem == ".." {
+			return "", fmt.Errorf("audit log path %q must not contain ..", path)
+		}
+	}
+
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(path))
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("audit log path %q must be inside %s", path, dir)
+	}
+
+	return filepath.Clean(path), nil
+}
+
+// record writes rec to the sink when it is sampled.
+func (a *auditor) record(ctx context.Context, rec auditRecord) {
+	if a == nil || a.sample() >= a.sampleRate {
+		return
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+
+	if err := a.sink.write(append(line, '\n')); err != nil {
+		log.DefaultLogger.FromContext(ctx).Error("Write audit log", "error", err)
+	}
+}
+
+func (a *auditor) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.sink.Close()
+}
+
+// newAuditRecord describes a query run for the user of pCtx, which started at
+// start and returned rows with status.
+func newAuditRecord(pCtx backend.PluginContext, refID string, querySource string, sql string, start time.Time, rows int64, status string) auditRecord {
+	if querySource == "" {
+		querySource = "default"
+	}
+
+	rec := auditRecord{
+		Timestamp:   start.UTC(),
+		OrgID:       pCtx.OrgID,
+		RefID:       refID,
+		QuerySource: querySource,
+		SQL:         sql,
+		Datasets:    referencedDatasets(sql),
+		Rows:        rows,
+		Status:      status,
+		DurationMs:  time.Since(start).Milliseconds(),
+	}
+
+	if pCtx.User != nil {
+		rec.User = pCtx.User.Login
+		rec.Email = pCtx.User.Email
+	}
+
+	if pCtx.DataSourceInstanceSettings != nil {
+		rec.DatasourceUID = pCtx.DataSourceInstanceSettings.UID
+	}
+
+	return rec
+}
+
+// auditQuery records a query run outside of a data query response, by a
+// resource handler or a stream, which returned rows or failed with err.
+func (d *Datasource) auditQuery(ctx context.Context, pCtx backend.PluginContext, querySource string, sql string, start time.Time, rows int64, err error) {
+	if d.audit == nil {
+		return
+	}
+
+	status := "ok"
+	if err != nil {
+		status = responseStatus(errorResponse(err))
+	}
+
+	d.audit.record(ctx, newAuditRecord(pCtx, "", querySource, sql, start, rows, status))
+}
+
+// referencedDatasets returns the tables referenced by FROM and JOIN clauses
+// of sql, without quotes and in order of appearance.
+func referencedDatasets(sql string) []string {
+	datasets := []string{}
+	seen := map[string]bool{}
+
+	for _, m := range sqlTableReference.FindAllStringSubmatch(sql, -1) {
+		name := strings.ReplaceAll(m[1], `"`, "")
+		if !seen[name] {
+			seen[name] = true
+			datasets = append(datasets, name)
+		}
+	}
+
+	return datasets
+}
+
+// writerSink writes audit lines to stdout or a file.
+type writerSink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+func (s *writerSink) write(line []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.w.Write(line)
+	return err
+}
+
+func (s *writerSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// webhookSink posts audit lines to a webhook from a bounded queue, so a slow
+// webhook does not delay queries. Lines are dropped while the queue is full.
+type webhookSink struct {
+	url    string
+	client *http.Client
+
+	mu     sync.Mutex
+	closed bool
+	queue  chan []byte
+	done   chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newWebhookSink(url string, client *http.Client) *webhookSink {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &webhookSink{
+		url:    url,
+		client: client,
+		queue:  make(chan []byte, auditWebhookQueueSize),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	go s.run()
+
+	return s
+}
+
+func (s *webhookSink) write(line []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errors.New("audit webhook is closed")
+	}
+
+	select {
+	case s.queue <- line:
+		return nil
+	default:
+		return errors.New("audit webhook queue is full, record dropped")
+	}
+}
+
+// run posts the queued lines until the queue is closed and drained.
+func (s *webhookSink) run() {
+	defer close(s.done)
+
+	for line := range s.queue {
+		if err := s.post(line); err != nil {
+			log.DefaultLogger.Error("Post audit log", "error", err)
+		}
+	}
+}
+
+func (s *webhookSink) post(line []byte) error {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.url, bytes.NewReader(line))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("webhook returned status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// Close stops accepting lines and waits for the queued ones to be posted.
+// Posts still running after the drain timeout are cancelled.
+func (s *webhookSink) Close() error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-s.done:
+	case <-time.After(auditWebhookDrainTimeout):
+		s.cancel()
+		<-s.done
+	}
+
+	s.cancel()
+	return nil
+}
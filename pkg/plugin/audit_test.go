@@ -0,0 +1,224 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestReferencedDatasets(t *testing.T) {
+	sql := `SELECT * FROM (SELECT * FROM eth.recent_blocks b JOIN "eth"."recent_transactions" t ON b.number = t.block_number) AS adhoc_filtered
+		WHERE b.number IN (SELECT number FROM eth.recent_blocks)`
+
+	datasets := referencedDatasets(sql)
+	if !reflect.DeepEqual(datasets, []string{"eth.recent_blocks", "eth.recent_transactions"}) {
+		t.Fatalf("wrong datasets, %v", datasets)
+	}
+}
+
+func TestAuditor(t *testing.T) {
+	pCtx := backend.PluginContext{
+		OrgID:                      2,
+		User:                       &backend.User{Login: "admin", Email: "admin@example.com"},
+		DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{UID: "spice"},
+	}
+	start := time.Now()
+
+	t.Run("record", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		a := &auditor{sink: &writerSink{w: buf}, sampleRate: 1, sample: func() float64 { return 0.5 }}
+
+		a.record(context.Background(), newAuditRecord(pCtx, "A", "", "SELECT * FROM eth.recent_blocks", start, 3, "ok"))
+
+		rec := auditRecord{}
+		if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+
+		if rec.User != "admin" || rec.OrgID != 2 || rec.DatasourceUID != "spice" || rec.Rows != 3 || rec.Status != "ok" {
+			t.Fatalf("wrong record, %+v", rec)
+		}
+
+		if len(rec.Datasets) != 1 || rec.Datasets[0] != "eth.recent_blocks" {
+			t.Fatalf("wrong datasets, %v", rec.Datasets)
+		}
+	})
+
+	t.Run("error status", func(t *testing.T) {
+		rec := newAuditRecord(pCtx, "A", "", "SELECT 1", start, 0, responseStatus(backend.DataResponse{Error: errors.New("denied"), Status: backend.StatusForbidden}))
+		if rec.Status != "403" {
+			t.Fatalf("wrong status, %v", rec.Status)
+		}
+	})
+
+	t.Run("sampling", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		a := &auditor{sink: &writerSink{w: buf}, sampleRate: 0.1, sample: func() float64 { return 0.5 }}
+
+		a.record(context.Background(), auditRecord{})
+		if buf.Len() != 0 {
+			t.Fatal("unsampled query must not be recorded")
+		}
+	})
+
+	t.Run("sample rate", func(t *testing.T) {
+		zero := 0.0
+		for _, tc := range []struct {
+			rate *float64
+			want float64
+		}{
+			{nil, 1},
+			{&zero, 0},
+		} {
+			a, err := newAuditor(datasourceSettings{AuditSink: auditSinkStdout, AuditSampleRate: tc.rate})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if a.sampleRate != tc.want {
+				t.Fatalf("wrong sample rate, %v", a.sampleRate)
+			}
+		}
+	})
+
+	t.Run("webhook", func(t *testing.T) {
+		var mu sync.Mutex
+		received := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			received++
+			mu.Unlock()
+		}))
+		defer server.Close()
+
+		sink := newWebhookSink(server.URL, server.Client())
+		for i := 0; i < 10; i++ {
+			if err := sink.write([]byte("{}\n")); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if received != 10 {
+			t.Fatalf("queued records must be posted on close, %v posted", received)
+		}
+
+		if err := sink.write([]byte("{}\n")); err == nil {
+			t.Fatal("closed sink must reject records")
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		a, err := newAuditor(datasourceSettings{})
+		if err != nil || a != nil {
+			t.Fatalf("auditing must be disabled by default, %v", err)
+		}
+
+		a.record(context.Background(), auditRecord{})
+	})
+
+	t.Run("file", func(t *testing.T) {
+		dir := t.TempDir()
+		t.Setenv(auditLogDirEnv, dir)
+
+		a, err := newAuditor(datasourceSettings{AuditSink: auditSinkFile, AuditPath: filepath.Join(dir, "audit.log")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.Close()
+
+		for _, path := range []string{"", "audit.log", dir + "/../audit.log", dir + "/logs/../audit.log", "/etc/passwd", dir} {
+			if _, err := newAuditor(datasourceSettings{AuditSink: auditSinkFile, AuditPath: path}); err == nil {
+				t.Fatalf("audit log path %q must be rejected", path)
+			}
+		}
+
+		t.Setenv(auditLogDirEnv, "")
+		if _, err := newAuditor(datasourceSettings{AuditSink: auditSinkFile, AuditPath: filepath.Join(dir, "audit.log")}); err == nil {
+			t.Fatal("file audit log must be disabled without a directory")
+		}
+	})
+
+	t.Run("invalid sink", func(t *testing.T) {
+		if _, err := newAuditor(datasourceSettings{AuditSink: auditSinkWebhook}); err == nil {
+			t.Fatal("webhook sink without URL must fail")
+		}
+
+		if _, err := newAuditor(datasourceSettings{AuditSink: "syslog"}); err == nil {
+			t.Fatal("unknown sink must fail")
+		}
+	})
+}
+
+func TestAuditedQueries(t *testing.T) {
+	buf := &bytes.Buffer{}
+	ds := &Datasource{
+		spice:          &testSpiceClient{err: status.Error(codes.PermissionDenied, "denied")},
+		settings:       backend.DataSourceInstanceSettings{UID: "spice"},
+		variablesCache: newTTLCache[[]variableValue]("variables", variablesCacheTTL),
+		audit:          &auditor{sink: &writerSink{w: buf}, sampleRate: 1, sample: func() float64 { return 0 }},
+	}
+
+	lastRecord := func(t *testing.T) auditRecord {
+		t.Helper()
+
+		lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+		rec := auditRecord{}
+		if err := json.Unmarshal(lines[len(lines)-1], &rec); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	t.Run("variables", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/variables", strings.NewReader(`{"queryText": "SELECT chain FROM eth.blocks"}`))
+		ds.handleVariables(httptest.NewRecorder(), req)
+
+		rec := lastRecord(t)
+		if rec.SQL != "SELECT chain FROM eth.blocks" || rec.Status != "403" {
+			t.Fatalf("wrong record, %+v", rec)
+		}
+	})
+
+	t.Run("partial stream", func(t *testing.T) {
+		sender := backend.NewStreamSender(&testPacketSender{})
+		if err := ds.runPartialStream(context.Background(), backend.PluginContext{OrgID: 1}, "stream/logs", spiceQuery{QueryText: "SELECT * FROM eth.logs"}, sender); err != nil {
+			t.Fatal(err)
+		}
+
+		rec := lastRecord(t)
+		if rec.SQL != "SELECT * FROM eth.logs" || rec.Status != "403" || rec.OrgID != 1 {
+			t.Fatalf("wrong record, %+v", rec)
+		}
+	})
+
+	t.Run("stream query placeholder", func(t *testing.T) {
+		buf.Reset()
+
+		ds.query(context.Background(), backend.PluginContext{}, backend.DataQuery{
+			RefID: "A",
+			JSON:  json.RawMessage(`{"queryText": "SELECT * FROM eth.blocks", "stream": true}`),
+		})
+
+		if buf.Len() != 0 {
+			t.Fatal("stream queries must be audited when they run")
+		}
+	})
+}
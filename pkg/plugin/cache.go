@@ -0,0 +1,78 @@
+package plugin
+
+import (
+	"sync"
+	"time"
+)
+
+// ttlCache is a concurrency safe cache whose entries expire after a fixed
+// TTL. A nil cache never holds any entries. Lookups are counted in the cache
+// metrics under the cache name. Expired entries are swept, at most once per
+// TTL, when an entry is set, so keys never looked up again do not pile up.
+type ttlCache[T any] struct {
+	mu      sync.Mutex
+	name    string
+	ttl     time.Duration
+	entries map[string]cacheEntry[T]
+	swept   time.Time
+}
+
+type cacheEntry[T any] struct {
+	value   T
+	expires time.Time
+}
+
+func newTTLCache[T any](name string, ttl time.Duration) *ttlCache[T] {
+	return &ttlCache[T]{
+		name:    name,
+		ttl:     ttl,
+		entries: map[string]cacheEntry[T]{},
+	}
+}
+
+func (c *ttlCache[T]) get(key string) (T, bool) {
+	var zero T
+	if c == nil {
+		return zero, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if ok && time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		ok = false
+	}
+
+	observeCacheLookup(c.name, ok)
+	if !ok {
+		return zero, false
+	}
+
+	return entry.value, true
+}
+
+func (c *ttlCache[T]) set(key string, value T) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.swept) >= c.ttl {
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.swept = now
+	}
+
+	c.entries[key] = cacheEntry[T]{
+		value:   value,
+		expires: now.Add(c.ttl),
+	}
+}
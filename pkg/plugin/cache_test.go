@@ -0,0 +1,51 @@
+package plugin
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTTLCache(t *testing.T) {
+	t.Run("get before expiry", func(t *testing.T) {
+		c := newTTLCache[int]("test", time.Minute)
+		c.set("a", 1)
+
+		v, ok := c.get("a")
+		if !ok || v != 1 {
+			t.Fatal("wrong value")
+		}
+	})
+
+	t.Run("get after expiry", func(t *testing.T) {
+		c := newTTLCache[int]("test", time.Nanosecond)
+		c.set("a", 1)
+		time.Sleep(time.Millisecond)
+
+		if _, ok := c.get("a"); ok {
+			t.Fatal("entry must expire")
+		}
+	})
+
+	t.Run("sweep on set", func(t *testing.T) {
+		c := newTTLCache[int]("test", time.Millisecond)
+		for i := 0; i < 1000; i++ {
+			c.set(strconv.Itoa(i), i)
+		}
+		time.Sleep(2 * time.Millisecond)
+		c.set("a", 1)
+
+		if len(c.entries) != 1 {
+			t.Fatalf("expired entries must be swept, %v", len(c.entries))
+		}
+	})
+
+	t.Run("nil cache", func(t *testing.T) {
+		var c *ttlCache[int]
+		c.set("a", 1)
+
+		if _, ok := c.get("a"); ok {
+			t.Fatal("nil cache must be empty")
+		}
+	})
+}
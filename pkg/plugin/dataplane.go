@@ -0,0 +1,82 @@
+package plugin
+
+import (
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// formatNumeric is the query format producing dataplane numeric or time
+// series frames, as expected by alert rules and server-side expressions.
+const formatNumeric = "numeric"
+
+// numericFrame converts a table frame into a multi-dimensional frame whose
+// string columns are labels on its numeric fields. Tables with a time column
+// become wide time series, converted from long ones when they have label
+// columns; tables without one become numeric wide frames with a single value
+// per numeric field and row, which requires the label columns to differ
+// between rows so no two series share a name and labels.
+func numericFrame(frame *data.Frame) (*data.Frame, error) {
+	timeSeriesMeta := &data.FrameMeta{
+		Type:        data.FrameTypeTimeSeriesWide,
+		TypeVersion: data.FrameTypeVersion{0, 1},
+	}
+
+	switch frame.TimeSeriesSchema().Type {
+	case data.TimeSeriesTypeWide:
+		frame.SetMeta(timeSeriesMeta)
+		return frame, nil
+	case data.TimeSeriesTypeLong:
+		if frame.Rows() == 0 {
+			break
+		}
+
+		wide, err := data.LongToWide(frame, nil)
+		if err != nil {
+			return nil, fmt.Errorf("convert to time series (is the query ordered by time?): %w", err)
+		}
+
+		wide.SetMeta(timeSeriesMeta)
+		return wide, nil
+	}
+
+	numeric := data.NewFrame(frame.Name)
+	numeric.SetMeta(&data.FrameMeta{
+		Type:        data.FrameTypeNumericWide,
+		TypeVersion: data.FrameTypeVersion{0, 1},
+	})
+
+	seen := map[string]bool{}
+
+	for row := 0; row < frame.Rows(); row++ {
+		labels := data.Labels{}
+		key := ""
+
+		for _, field := range frame.Fields {
+			if field.Type() == data.FieldTypeString || field.Type() == data.FieldTypeNullableString {
+				labels[field.Name] = fieldValueString(field, row)
+				key += labels[field.Name] + "\x00"
+			}
+		}
+
+		if seen[key] {
+			return nil, fmt.Errorf("numeric format needs distinct label columns per row, labels {%s} appear more than once", labels)
+		}
+		seen[key] = true
+
+		for _, field := range frame.Fields {
+			if !field.Type().Numeric() {
+				continue
+			}
+
+			value := data.NewFieldFromFieldType(field.Type(), 1)
+			value.Name = field.Name
+			value.Labels = labels.Copy()
+			value.Set(0, field.At(row))
+
+			numeric.Fields = append(numeric.Fields, value)
+		}
+	}
+
+	return numeric, nil
+}
@@ -0,0 +1,105 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestNumericFrame(t *testing.T) {
+	t.Run("numeric wide", func(t *testing.T) {
+		frame := data.NewFrame("response",
+			data.NewField("chain", nil, []string{"eth", "btc"}),
+			data.NewField("blocks", nil, []int64{10, 20}),
+			data.NewField("fees", nil, []float64{1.5, 2.5}))
+
+		numeric, err := numericFrame(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if numeric.Meta.Type != data.FrameTypeNumericWide {
+			t.Fatalf("wrong frame type, %v", numeric.Meta.Type)
+		}
+
+		if len(numeric.Fields) != 4 {
+			t.Fatalf("wrong number of fields, %v", len(numeric.Fields))
+		}
+
+		field := numeric.Fields[2]
+		if field.Name != "blocks" || field.Labels["chain"] != "btc" || field.At(0) != int64(20) {
+			t.Fatalf("wrong field, %v %v %v", field.Name, field.Labels, field.At(0))
+		}
+	})
+
+	t.Run("time series", func(t *testing.T) {
+		now := time.Now().UTC()
+		frame := data.NewFrame("response",
+			data.NewField("time", nil, []time.Time{now, now, now.Add(time.Minute), now.Add(time.Minute)}),
+			data.NewField("chain", nil, []string{"btc", "eth", "btc", "eth"}),
+			data.NewField("blocks", nil, []int64{1, 2, 3, 4}))
+
+		wide, err := numericFrame(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if wide.Meta.Type != data.FrameTypeTimeSeriesWide {
+			t.Fatalf("wrong frame type, %v", wide.Meta.Type)
+		}
+
+		if len(wide.Fields) != 3 {
+			t.Fatalf("wrong number of fields, %v", len(wide.Fields))
+		}
+	})
+	t.Run("wide time series", func(t *testing.T) {
+		now := time.Now().UTC()
+		frame := data.NewFrame("response",
+			data.NewField("time", nil, []time.Time{now, now.Add(time.Minute)}),
+			data.NewField("value", nil, []float64{1, 2}))
+
+		wide, err := numericFrame(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if wide.Meta.Type != data.FrameTypeTimeSeriesWide {
+			t.Fatalf("wrong frame type, %v", wide.Meta.Type)
+		}
+
+		if len(wide.Fields) != 2 || wide.Rows() != 2 {
+			t.Fatalf("wrong frame shape, %v fields %v rows", len(wide.Fields), wide.Rows())
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		frame := data.NewFrame("response",
+			data.NewField("time", nil, []time.Time{}),
+			data.NewField("chain", nil, []string{}),
+			data.NewField("value", nil, []float64{}))
+
+		numeric, err := numericFrame(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if numeric.Rows() != 0 {
+			t.Fatalf("wrong number of rows, %v", numeric.Rows())
+		}
+	})
+
+	t.Run("duplicate labels", func(t *testing.T) {
+		for name, frame := range map[string]*data.Frame{
+			"no label columns": data.NewFrame("response",
+				data.NewField("a", nil, []int64{1, 2})),
+			"repeated labels": data.NewFrame("response",
+				data.NewField("chain", nil, []string{"eth", "btc", "eth"}),
+				data.NewField("a", nil, []int64{1, 2, 3})),
+		} {
+			if _, err := numericFrame(frame); err == nil {
+				t.Fatalf("%v must be rejected", name)
+			}
+		}
+	})
+}
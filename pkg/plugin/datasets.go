@@ -0,0 +1,211 @@
+package plugin
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const datasetsCacheTTL = 5 * time.Minute
+
+// errNoDatasetsURL is returned when no datasets API is configured.
+var errNoDatasetsURL = errors.New("no datasets URL configured")
+
+// upstreamError is returned when the Spice datasets API responds with a
+// non-2xx status.
+type upstreamError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *upstreamError) Error() string {
+	return fmt.Sprintf("datasets api returned %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+// listDatasets returns the dataset catalog, served from the cache while it is
+// fresh.
+func (d *Datasource) listDatasets(ctx context.Context) ([]map[string]interface{}, error) {
+	key := identityCacheKey(ctx, d.config.DatasetsURL)
+	if datasets, ok := d.datasetsCache.get(key); ok {
+		return datasets, nil
+	}
+
+	datasets, err := d.fetchDatasets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	d.datasetsCache.set(key, datasets)
+	return datasets, nil
+}
+
+// fetchDatasets requests the dataset catalog from the Spice datasets API with
+// the active API key, failing over to the other key when it is rejected.
+func (d *Datasource) fetchDatasets(ctx context.Context) ([]map[string]interface{}, error) {
+	f, ok := d.spice.(*failoverClient)
+	if !ok {
+		return d.requestDatasets(ctx, d.apiKey())
+	}
+
+	return failover(f, func(k keyedClient) ([]map[string]interface{}, error) {
+		return d.requestDatasets(ctx, k.apiKey)
+	}, isRejectedKey)
+}
+
+// isRejectedKey reports whether the datasets API rejected the API key.
+func isRejectedKey(err error) bool {
+	var upstreamErr *upstreamError
+	return errors.As(err, &upstreamErr) &&
+		(upstreamErr.StatusCode == http.StatusUnauthorized || upstreamErr.StatusCode == http.StatusForbidden)
+}
+
+// requestDatasets requests the dataset catalog with apiKey.
+func (d *Datasource) requestDatasets(ctx context.Context, apiKey string) ([]map[string]interface{}, error) {
+	if d.config.DatasetsURL == "" {
+		return nil, errNoDatasetsURL
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.config.DatasetsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	setIdentityHeaders(ctx, req)
+	req.Header.Set(apiKeyHeader, apiKey)
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	reader, err := decodedBody(res)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	if res.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(reader, 4096))
+		return nil, &upstreamError{StatusCode: res.StatusCode, Body: string(body)}
+	}
+
+	var datasets []map[string]interface{}
+	if err := json.NewDecoder(reader).Decode(&datasets); err != nil {
+		return nil, fmt.Errorf("json decode datasets: %w", err)
+	}
+
+	return datasets, nil
+}
+
+// decodedBody returns the response body, decompressed according to its
+// Content-Encoding.
+func decodedBody(res *http.Response) (io.ReadCloser, error) {
+	switch res.Header.Get("Content-Encoding") {
+	case "gzip":
+		return gzip.NewReader(res.Body)
+	case "deflate":
+		return zlib.NewReader(res.Body)
+	default:
+		return io.NopCloser(res.Body), nil
+	}
+}
+
+// handleDatasets serves the dataset catalog, filtered by the optional search
+// query parameter and paginated with limit and offset. The total number of
+// matching datasets is returned in the X-Total-Count header.
+func (d *Datasource) handleDatasets(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	offset, err := intParam(params, "offset", 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	limit, err := intParam(params, "limit", -1)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	datasets, err := d.listDatasets(r.Context())
+	if err != nil {
+		var upstreamErr *upstreamError
+		if errors.As(err, &upstreamErr) {
+			writeError(w, upstreamErr.StatusCode, err)
+			return
+		}
+		if errors.Is(err, errNoDatasetsURL) {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	datasets = searchDatasets(datasets, params.Get("search"))
+	total := len(datasets)
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	writeJSON(w, http.StatusOK, paginate(datasets, offset, limit))
+}
+
+// searchDatasets returns the datasets whose name contains search, ignoring
+// case.
+func searchDatasets(datasets []map[string]interface{}, search string) []map[string]interface{} {
+	if search == "" {
+		return datasets
+	}
+
+	search = strings.ToLower(search)
+	matches := []map[string]interface{}{}
+
+	for _, dataset := range datasets {
+		name, _ := dataset["name"].(string)
+		if strings.Contains(strings.ToLower(name), search) {
+			matches = append(matches, dataset)
+		}
+	}
+
+	return matches
+}
+
+// paginate returns the page of items starting at offset. A negative limit
+// returns all remaining items.
+func paginate[T any](items []T, offset int, limit int) []T {
+	if offset >= len(items) {
+		return []T{}
+	}
+
+	items = items[offset:]
+	if limit >= 0 && limit < len(items) {
+		items = items[:limit]
+	}
+
+	return items
+}
+
+// intParam parses the non-negative integer query parameter key, returning def
+// when it is not set.
+func intParam(params url.Values, key string, def int) (int, error) {
+	value := params.Get(key)
+	if value == "" {
+		return def, nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("invalid %s parameter: %q", key, value)
+	}
+
+	return i, nil
+}
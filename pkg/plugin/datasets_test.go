@@ -0,0 +1,162 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func datasetsTestDatasource(status int, body string) (*Datasource, *int) {
+	calls := 0
+	return &Datasource{
+		client: http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				calls++
+				return &http.Response{
+					StatusCode: status,
+					Status:     http.StatusText(status),
+					Header:     http.Header{},
+					Body:       io.NopCloser(strings.NewReader(body)),
+				}, nil
+			}),
+		},
+		config:        datasourceSettings{DatasetsURL: defaultDatasetsURL},
+		datasetsCache: newTTLCache[[]map[string]interface{}]("datasets", time.Minute),
+	}, &calls
+}
+
+func TestHandleDatasets(t *testing.T) {
+	catalog := `[{"name":"eth.recent_blocks"},{"name":"eth.recent_transactions"},{"name":"btc.recent_blocks"}]`
+
+	t.Run("search and pagination", func(t *testing.T) {
+		ds, calls := datasetsTestDatasource(http.StatusOK, catalog)
+		router := ds.newResourceHandler()
+
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/datasets?search=ETH&offset=1&limit=5", nil))
+
+		if res.Code != http.StatusOK {
+			t.Fatalf("wrong status, %v", res.Code)
+		}
+
+		var datasets []map[string]interface{}
+		if err := json.Unmarshal(res.Body.Bytes(), &datasets); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(datasets) != 1 || datasets[0]["name"] != "eth.recent_transactions" {
+			t.Fatalf("wrong datasets, %v", datasets)
+		}
+
+		if res.Header().Get("X-Total-Count") != "2" {
+			t.Fatalf("wrong total count, %v", res.Header().Get("X-Total-Count"))
+		}
+
+		// the second request must be served from the cache
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/datasets", nil))
+
+		if *calls != 1 {
+			t.Fatalf("wrong number of upstream calls, %v", *calls)
+		}
+	})
+
+	t.Run("upstream error", func(t *testing.T) {
+		ds, _ := datasetsTestDatasource(http.StatusUnauthorized, `{"error":"invalid api key"}`)
+
+		res := httptest.NewRecorder()
+		ds.newResourceHandler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/datasets", nil))
+
+		if res.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong status, %v", res.Code)
+		}
+
+		if !strings.Contains(res.Body.String(), "invalid api key") {
+			t.Fatalf("missing upstream error body, %s", res.Body)
+		}
+	})
+
+	t.Run("invalid limit", func(t *testing.T) {
+		ds, _ := datasetsTestDatasource(http.StatusOK, catalog)
+
+		res := httptest.NewRecorder()
+		ds.newResourceHandler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/datasets?limit=-1", nil))
+
+		if res.Code != http.StatusBadRequest {
+			t.Fatalf("wrong status, %v", res.Code)
+		}
+	})
+	t.Run("api key failover", func(t *testing.T) {
+		ds := &Datasource{
+			spice: newFailoverClient(
+				keyedClient{name: primaryKey, apiKey: "1|revoked", client: &testSpiceClient{}},
+				keyedClient{name: secondaryKey, apiKey: "1|rotated", client: &testSpiceClient{}}),
+			client: http.Client{
+				Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+					status := http.StatusOK
+					if req.Header.Get(apiKeyHeader) != "1|rotated" {
+						status = http.StatusUnauthorized
+					}
+					return &http.Response{
+						StatusCode: status,
+						Header:     http.Header{},
+						Body:       io.NopCloser(strings.NewReader(catalog)),
+					}, nil
+				}),
+			},
+			config:        datasourceSettings{DatasetsURL: defaultDatasetsURL},
+			datasetsCache: newTTLCache[[]map[string]interface{}]("datasets", time.Minute),
+		}
+
+		res := httptest.NewRecorder()
+		ds.newResourceHandler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/datasets", nil))
+
+		if res.Code != http.StatusOK {
+			t.Fatalf("wrong status, %v", res.Code)
+		}
+
+		if name := ds.activeAPIKey().name; name != secondaryKey {
+			t.Fatalf("secondary key must be active, %v", name)
+		}
+	})
+}
+
+func TestSelfHostedDatasets(t *testing.T) {
+	ds, calls := datasetsTestDatasource(http.StatusOK, "[]")
+	ds.config = datasourceSettings{FlightAddress: "spice.internal:50051"}
+
+	res := httptest.NewRecorder()
+	ds.newResourceHandler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/datasets", nil))
+
+	if res.Code != http.StatusNotFound || *calls != 0 {
+		t.Fatalf("datasets must not be requested without a datasets url, %v %v", res.Code, *calls)
+	}
+
+	health, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var details healthDetails
+	if err := json.Unmarshal(health.JSONDetails, &details); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, stage := range details.Stages {
+		if (stage.Name == "http" || stage.Name == "datasets") && (stage.Status != stageSkipped || stage.Message != errNoDatasetsURL.Error()) {
+			t.Fatalf("wrong %v stage, %+v", stage.Name, stage)
+		}
+	}
+}
@@ -1,26 +1,23 @@
 package plugin
 
 import (
-	"compress/gzip"
-	"compress/zlib"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"time"
 
 	"github.com/apache/arrow/go/v14/arrow"
 	"github.com/apache/arrow/go/v14/arrow/array"
+	"github.com/apache/arrow/go/v14/arrow/util"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
-	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
-
-	"github.com/spiceai/gospice/v4"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
 // Make sure Datasource implements required interfaces. This is important to do
@@ -31,51 +28,101 @@ import (
 var (
 	_ backend.QueryDataHandler      = (*Datasource)(nil)
 	_ backend.CheckHealthHandler    = (*Datasource)(nil)
+	_ backend.CallResourceHandler   = (*Datasource)(nil)
+	_ backend.StreamHandler         = (*Datasource)(nil)
 	_ instancemgmt.InstanceDisposer = (*Datasource)(nil)
 )
 
 // NewDatasource creates a new datasource instance.
 func NewDatasource(ctx context.Context, settings backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
 	apiKey := settings.DecryptedSecureJSONData["apiKey"]
-
-	spice := gospice.NewSpiceClient()
+	secondaryAPIKey := settings.DecryptedSecureJSONData["secondaryApiKey"]
 
 	if apiKey == "" {
 		return nil, fmt.Errorf("missing Spice AI apiKey")
 	}
 
-	if err := spice.Init(apiKey); err != nil {
-		return nil, fmt.Errorf("failed to initialize gospice: %w", err)
+	config, err := loadSettings(settings)
+	if err != nil {
+		return nil, err
 	}
 
 	opts, err := settings.HTTPClientOptions(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("http client options: %w", err)
 	}
-	opts.Headers = map[string]string{
-		"Content-Type":    "application/json",
-		"Accept-Encoding": "gzip, deflate",
-		"X-API-Key":       apiKey,
-	}
+	config.applyTLSOptions(&opts)
+	md := customMetadata(opts.Headers)
+	opts.Headers = mergeHeaders(opts.Headers)
 
 	client, err := httpclient.New(opts)
 	if err != nil {
 		return nil, fmt.Errorf("httpclient new: %w", err)
 	}
 
-	return &Datasource{
-		spice:    *spice,
-		client:   *client,
-		settings: settings,
-	}, nil
+	audit, err := newAuditor(config)
+	if err != nil {
+		return nil, err
+	}
+
+	dialOpts, err := flightDialOptions(opts)
+	if err != nil {
+		audit.Close()
+		return nil, err
+	}
+
+	primary, err := newSpiceClient(config, apiKey, opts)
+	if err != nil {
+		audit.Close()
+		return nil, err
+	}
+
+	clients := []keyedClient{{name: primaryKey, apiKey: apiKey, client: primary}}
+
+	if secondaryAPIKey != "" {
+		secondary, err := newSpiceClient(config, secondaryAPIKey, opts)
+		if err != nil {
+			primary.Close()
+			audit.Close()
+			return nil, err
+		}
+
+		clients = append(clients, keyedClient{name: secondaryKey, apiKey: secondaryAPIKey, client: secondary})
+	}
+
+	spice := newFailoverClient(clients...)
+
+	ds := &Datasource{
+		spice:          spice,
+		dialOpts:       dialOpts,
+		metadata:       md,
+		client:         *client,
+		settings:       settings,
+		config:         config,
+		datasetsCache:  newTTLCache[[]map[string]interface{}]("datasets", datasetsCacheTTL),
+		variablesCache: newTTLCache[[]variableValue]("variables", variablesCacheTTL),
+		audit:          audit,
+	}
+	ds.resourceHandler = httpadapter.New(ds.newResourceHandler())
+
+	return ds, nil
 }
 
 // Datasource is an example datasource which can respond to data queries, reports
 // its health and has streaming skills.
 type Datasource struct {
-	spice    gospice.SpiceClient
+	spice    spiceClient
+	dialOpts []grpc.DialOption
+	metadata metadata.MD
 	settings backend.DataSourceInstanceSettings
+	config   datasourceSettings
 	client   http.Client
+
+	datasetsCache   *ttlCache[[]map[string]interface{}]
+	variablesCache  *ttlCache[[]variableValue]
+	resourceHandler backend.CallResourceHandler
+	live            liveQueries
+	audit           *auditor
 }
 
 // Dispose here tells plugin SDK that plugin wants to clean up resources when a new instance
@@ -83,6 +130,7 @@ type Datasource struct {
 // be disposed and a new one will be created using NewSampleDatasource factory function.
 func (d *Datasource) Dispose() {
 	d.spice.Close()
+	d.audit.Close()
 }
 
 func arrowColumnToArray(field arrow.Field, columnType arrow.Type, column arrow.Array) interface{} {
@@ -188,6 +236,20 @@ func arrowColumnToArray(field arrow.Field, columnType arrow.Type, column arrow.A
 		}
 		return arr
 
+	case arrow.DATE32:
+		arr := make([]time.Time, length)
+		for i := 0; i < column.Len(); i++ {
+			arr[i] = column.(*array.Date32).Value(i).ToTime()
+		}
+		return arr
+
+	case arrow.DATE64:
+		arr := make([]time.Time, length)
+		for i := 0; i < column.Len(); i++ {
+			arr[i] = column.(*array.Date64).Value(i).ToTime()
+		}
+		return arr
+
 	case arrow.LIST:
 		arr := make([]string, length)
 		for i := 0; i < column.Len(); i++ {
@@ -267,7 +329,7 @@ func appendColumnToField(field *data.Field, columnType arrow.Type, column interf
 			field.Append(column.([]float64)[j])
 		}
 
-	case arrow.TIMESTAMP:
+	case arrow.TIMESTAMP, arrow.DATE32, arrow.DATE64:
 		for j := range column.([]time.Time) {
 			field.Append(column.([]time.Time)[j])
 		}
@@ -279,6 +341,85 @@ func appendColumnToField(field *data.Field, columnType arrow.Type, column interf
 	}
 }
 
+// recordsToFrame reads every record batch from reader into a single frame,
+// collecting statistics about the batches read. Reading the results and
+// converting each batch are traced as spans of ctx.
+func recordsToFrame(ctx context.Context, reader array.RecordReader) (*data.Frame, queryStats) {
+	ctx, span := startSpan(ctx, "spice.read_results")
+	defer span.End()
+
+	schema := reader.Schema()
+
+	frame := data.NewFrame("response")
+	stats := queryStats{}
+	start := time.Now()
+
+	var page int64 = 0
+
+	for {
+		next := time.Now()
+		ok := reader.Next()
+		stats.upstream += time.Since(next)
+		if !ok {
+			break
+		}
+
+		if page == 0 {
+			stats.firstBatch = time.Since(start)
+			span.SetAttributes(attributeFirstBatch.Int64(stats.firstBatch.Milliseconds()))
+		}
+
+		convert := time.Now()
+		record := reader.Record()
+		_, batchSpan := startSpan(ctx, "spice.convert_batch", attributeRows.Int64(record.NumRows()))
+
+		stats.batches++
+		stats.rows += record.NumRows()
+		stats.bytes += util.TotalRecordSize(record)
+
+		// setup fields on first record
+		if page == 0 {
+			frame = recordToFrame(schema, record)
+		} else {
+			for i, field := range schema.Fields() {
+				column := record.Column(i)
+				defer column.Release()
+
+				columnType := field.Type.ID()
+
+				arr := arrowColumnToArray(field, columnType, column)
+
+				// append data to existing fields
+				appendColumnToField(frame.Fields[i], columnType, arr)
+			}
+		}
+
+		batchSpan.End()
+		stats.conversion += time.Since(convert)
+		page++
+	}
+
+	span.SetAttributes(
+		attributeBatches.Int64(stats.batches),
+		attributeRows.Int64(stats.rows),
+		attributeBytes.Int64(stats.bytes),
+	)
+
+	return frame, stats
+}
+
+// recordToFrame converts a single record batch to a frame.
+func recordToFrame(schema *arrow.Schema, record arrow.Record) *data.Frame {
+	frame := data.NewFrame("response")
+
+	for i, field := range schema.Fields() {
+		arr := arrowColumnToArray(field, field.Type.ID(), record.Column(i))
+		frame.Fields = append(frame.Fields, data.NewField(field.Name, nil, arr))
+	}
+
+	return frame
+}
+
 // QueryData handles multiple queries and returns multiple responses.
 // req contains the queries []DataQuery (where each query contains RefID as a unique identifier).
 // The QueryDataResponse contains a map of RefID to the response for each query, and each response
@@ -287,6 +428,8 @@ func (d *Datasource) QueryData(ctx context.Context, req *backend.QueryDataReques
 	// create response struct
 	response := backend.NewQueryDataResponse()
 
+	ctx = d.withUserIdentity(ctx, req.PluginContext, req.GetHTTPHeaders())
+
 	// loop over queries and execute them individually.
 	for _, q := range req.Queries {
 		res := d.query(ctx, req.PluginContext, q)
@@ -299,7 +442,17 @@ func (d *Datasource) QueryData(ctx context.Context, req *backend.QueryDataReques
 	return response, nil
 }
 
-func (d *Datasource) SpiceQuery(ctx context.Context, query string, querySource string) (array.RecordReader, error) {
+// SpiceQuery runs query on Spice using the query source, returning a reader
+// of its record batches. The custom metadata of the datasource, and the trace
+// context and user identity of ctx, are propagated to Spice.
+func (d *Datasource) SpiceQuery(ctx context.Context, query string, querySource string) (reader array.RecordReader, err error) {
+	ctx, span := startSpan(ctx, "spice.flight", attributeQuerySource.String(querySource))
+	defer func() { endSpan(span, err) }()
+
+	ctx = appendMetadata(ctx, d.metadata)
+	ctx = injectTraceContext(ctx)
+	ctx = identityMetadata(ctx)
+
 	switch querySource {
 	case "firecache":
 		return d.spice.FireQuery(ctx, query)
@@ -308,156 +461,136 @@ func (d *Datasource) SpiceQuery(ctx context.Context, query string, querySource s
 	}
 }
 
-func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery) backend.DataResponse {
-	var response backend.DataResponse
+func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery) (response backend.DataResponse) {
+	observer := observeQuery()
+	defer func() { observer.done(response) }()
+
+	ctx, span := startSpan(ctx, "spice.query", attributeRefID.String(query.RefID))
+	defer func() {
+		span.SetAttributes(attributeRows.Int64(observer.stats.rows))
+		endSpan(span, response.Error)
+	}()
+
+	logger := d.queryLogger(ctx, pCtx, query)
 
 	if len(query.JSON) == 0 {
-		return backend.ErrDataResponse(backend.StatusBadRequest, "empty query")
+		return backend.ErrDataResponseWithSource(backend.StatusBadRequest, backend.ErrorSourcePlugin, "empty query")
 	}
 
+	_, parseSpan := startSpan(ctx, "spice.parse_query")
 	q := &spiceQuery{}
 	err := json.Unmarshal(query.JSON, &q)
+	endSpan(parseSpan, err)
 	if err != nil {
-		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("json unmarshal: %v", err.Error()))
+		return backend.ErrDataResponseWithSource(backend.StatusBadRequest, backend.ErrorSourcePlugin, fmt.Sprintf("json unmarshal: %v", err.Error()))
 	}
+	observer.setSource(q.QuerySource)
+	span.SetAttributes(attributeQuerySource.String(observer.source))
+	logger = logger.With("queryHash", queryHash(*q), "querySource", observer.source)
 
-	reader, err := d.SpiceQuery(ctx, q.QueryText, q.QuerySource)
-
+	_, expandSpan := startSpan(ctx, "spice.expand_query")
+	sql, err := d.expandQuery(ctx, pCtx, *q)
+	endSpan(expandSpan, err)
 	if err != nil {
-		log.DefaultLogger.Error("err: %w", err)
-
-		errMsg := err.Error()
+		return backend.ErrDataResponseWithSource(backend.StatusBadRequest, backend.ErrorSourceDownstream, err.Error())
+	}
 
-		switch {
-		case errors.Is(err, context.DeadlineExceeded):
-			return backend.ErrDataResponse(backend.StatusTimeout, errMsg)
+	// The OAuth token of the user is only sent with data queries, so live and
+	// streamed queries, which run from the channel, cannot forward it.
+	if (q.Stream || q.Live) && d.config.OAuthPassThru && query.QueryType != queryTypeAnnotation {
+		return backend.ErrDataResponseWithSource(backend.StatusBadRequest, backend.ErrorSourcePlugin,
+			"live and streamed queries are not supported when the OAuth identity is forwarded")
+	}
 
-		case errMsg == "rpc error: code = Unknown desc = Exceeded concurrent request limit":
-			return backend.ErrDataResponse(backend.StatusTooManyRequests, errMsg)
+	// Streamed queries run, and are audited, when the channel is subscribed.
+	if q.Stream && query.QueryType != queryTypeAnnotation {
+		frame := data.NewFrame("response")
+		setFrameMeta(frame, sql, q.QuerySource, queryStats{})
+		frame.Meta.Channel = d.streamChannel(pCtx, *q)
 
-		default:
-			return backend.ErrDataResponse(backend.StatusInternal, errMsg)
-		}
+		response.Frames = append(response.Frames, frame)
+		return response
 	}
 
-	schema := reader.Schema()
+	defer func() {
+		d.audit.record(ctx, newAuditRecord(pCtx, query.RefID, observer.source, sql, observer.start, observer.stats.rows, responseStatus(response)))
+	}()
 
-	frame := data.NewFrame("response")
+	start := time.Now()
+	reader, err := d.SpiceQuery(ctx, sql, q.QuerySource)
+	upstream := time.Since(start)
 
-	var page int64 = 0
+	if err != nil {
+		logger.Error("Query failed", "error", d.redact(err.Error()), "duration", time.Since(start))
 
-	for reader.Next() {
-		record := reader.Record()
+		return errorResponse(err)
+	}
+	defer reader.Release()
 
-		for i, field := range schema.Fields() {
-			column := record.Column(i)
-			defer column.Release()
+	frame, stats := recordsToFrame(ctx, reader)
+	stats.elapsed = time.Since(start)
+	stats.upstream += upstream
+	stats.firstBatch += upstream
+	observer.stats = stats
 
-			columnType := field.Type.ID()
+	// An error after the first batch would otherwise return a truncated frame.
+	if err := reader.Err(); err != nil {
+		logger.Error("Query failed", "error", d.redact(err.Error()), "duration", stats.elapsed, "rows", stats.rows)
 
-			arr := arrowColumnToArray(field, columnType, column)
+		return errorResponse(err)
+	}
 
-			// setup fields on first record
-			if page == 0 {
-				frame.Fields = append(frame.Fields,
-					data.NewField(field.Name, nil, arr))
-			} else {
-				// append data to existing fields
-				appendColumnToField(frame.Fields[i], columnType, arr)
-			}
-		}
+	logger.Debug("Query completed", "duration", stats.elapsed, "rows", stats.rows, "batches", stats.batches)
 
-		page++
+	if d.config.slowQuery > 0 && stats.elapsed > d.config.slowQuery {
+		logger.Warn("Slow query", "duration", stats.elapsed, "rows", stats.rows, "batches", stats.batches, "sql", redactSQL(sql))
 	}
 
-	response.Frames = append(response.Frames, frame)
+	_, buildSpan := startSpan(ctx, "spice.build_frame")
+	defer buildSpan.End()
 
-	reader.Release()
-	return response
-}
+	watermark, _ := frameWatermark(frame, q.TimeColumn)
+	// An empty result has no fields, so its time column is found once rows
+	// arrive.
+	hasTimeColumn := len(frame.Fields) == 0 || timeFieldIndex(frame, q.TimeColumn) >= 0
 
-func (d *Datasource) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
-	switch req.Path {
-	case "datasets":
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://data.spiceai.io/v0.1/datasets", nil)
-		if err != nil {
-			return err
-		}
+	frame, err = shapeTimeFrame(frame, q.TimeColumn)
+	if err != nil {
+		return backend.ErrDataResponseWithSource(backend.StatusBadRequest, backend.ErrorSourceDownstream, err.Error())
+	}
 
-		res, err := d.client.Do(req)
+	if query.QueryType == queryTypeAnnotation {
+		frame, err = annotationFrame(frame)
 		if err != nil {
-			return err
-		}
-
-		defer res.Body.Close()
-
-		var jsonData []map[string]interface{}
-
-		var reader io.ReadCloser
-		switch res.Header.Get("Content-Encoding") {
-		case "gzip":
-			reader, err = gzip.NewReader(res.Body)
-			if err != nil {
-				return err
-			}
-			defer reader.Close()
-
-		case "deflate":
-			reader, err = zlib.NewReader(res.Body)
-			if err != nil {
-				return err
-			}
-			defer reader.Close()
-
-		default:
-			reader = res.Body
+			return backend.ErrDataResponseWithSource(backend.StatusBadRequest, backend.ErrorSourceDownstream, err.Error())
 		}
+	}
 
-		json.NewDecoder(reader).Decode(&jsonData)
-
-		data, err := json.Marshal(jsonData)
+	if q.Format == formatNumeric && query.QueryType != queryTypeAnnotation {
+		frame, err = numericFrame(frame)
 		if err != nil {
-			return err
+			return backend.ErrDataResponseWithSource(backend.StatusBadRequest, backend.ErrorSourceDownstream, err.Error())
 		}
-
-		return sender.Send(&backend.CallResourceResponse{
-			Status: http.StatusOK,
-			Body:   data,
-		})
-
-	default:
-		return sender.Send(&backend.CallResourceResponse{
-			Status: http.StatusNotFound,
-		})
 	}
-}
 
-// CheckHealth handles health checks sent from Grafana to the plugin.
-// The main use case for these health checks is the test button on the
-// datasource configuration page which allows users to verify that
-// a datasource is working as expected.
-func (d *Datasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
-	var status = backend.HealthStatusOk
-	var message = "Data source is working"
+	setFrameMeta(frame, sql, q.QuerySource, stats)
 
-	reader, err := d.spice.Query(ctx, "SELECT number FROM eth.recent_blocks LIMIT 1")
-	if err != nil {
-		status = backend.HealthStatusError
-		message = fmt.Sprintf("error querying: %v", err.Error())
+	if q.Live && query.QueryType != queryTypeAnnotation {
+		if hasTimeColumn {
+			frame.Meta.Channel = d.liveChannel(pCtx, *q, watermark)
+		} else {
+			frame.AppendNotices(data.Notice{Severity: data.NoticeSeverityWarning, Text: errNoLiveTimeColumn.Error()})
+		}
 	}
 
-	for reader.Next() {
-		record := reader.Record()
-		defer record.Release()
+	response.Frames = append(response.Frames, frame)
 
-		if record.NumRows() != 1 {
-			status = backend.HealthStatusError
-			message = "error querying"
-		}
-	}
+	return response
+}
 
-	return &backend.CheckHealthResult{
-		Status:  status,
-		Message: message,
-	}, nil
+// CallResource handles resource calls sent from Grafana to the plugin by
+// routing them to the datasource resource handlers.
+func (d *Datasource) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	ctx = d.withUserIdentity(ctx, req.PluginContext, req.GetHTTPHeaders())
+	return d.resourceHandler.CallResource(ctx, req, sender)
 }
@@ -12,6 +12,7 @@ import (
 	"github.com/apache/arrow/go/v14/arrow/decimal128"
 	"github.com/apache/arrow/go/v14/arrow/memory"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
 	"github.com/spiceai/gospice/v4"
 )
 
@@ -404,6 +405,44 @@ func TestArrowColumnToArray(t *testing.T) {
 			t.Fatalf("wrong value, %v %v", results[1].UTC(), now.UTC())
 		}
 	})
+
+	t.Run("arrow.DATE32", func(t *testing.T) {
+		columnType := arrow.DATE32
+		pool := memory.NewCheckedAllocator(memory.NewGoAllocator())
+		builder := array.NewDate32Builder(pool)
+		defer builder.Release()
+
+		day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+		builder.Append(arrow.Date32FromTime(day))
+
+		column := builder.NewDate32Array()
+		defer column.Release()
+
+		results := arrowColumnToArray(arrow.Field{Type: arrow.FixedWidthTypes.Date32}, columnType, column).([]time.Time)
+
+		if len(results) != 1 || !results[0].Equal(day) {
+			t.Fatalf("wrong value, %v", results)
+		}
+	})
+
+	t.Run("arrow.DATE64", func(t *testing.T) {
+		columnType := arrow.DATE64
+		pool := memory.NewCheckedAllocator(memory.NewGoAllocator())
+		builder := array.NewDate64Builder(pool)
+		defer builder.Release()
+
+		day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+		builder.Append(arrow.Date64FromTime(day))
+
+		column := builder.NewDate64Array()
+		defer column.Release()
+
+		results := arrowColumnToArray(arrow.Field{Type: arrow.FixedWidthTypes.Date64}, columnType, column).([]time.Time)
+
+		if len(results) != 1 || !results[0].Equal(day) {
+			t.Fatalf("wrong value, %v", results)
+		}
+	})
 }
 
 func TestQueryData(t *testing.T) {
@@ -415,7 +454,7 @@ func TestQueryData(t *testing.T) {
 	}
 
 	ds := Datasource{
-		spice: *spice,
+		spice: spice,
 	}
 
 	resp, err := ds.QueryData(
@@ -436,3 +475,52 @@ func TestQueryData(t *testing.T) {
 		t.Fatal("QueryData must return a response")
 	}
 }
+
+func TestCheckHealth(t *testing.T) {
+	spice := gospice.NewSpiceClient()
+	defer spice.Close()
+
+	if err := spice.Init("000000|invalid"); err != nil {
+		panic(fmt.Errorf("error initializing SpiceClient: %w", err))
+	}
+
+	dialOpts, err := flightDialOptions(httpclient.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds := Datasource{
+		spice:    spice,
+		dialOpts: dialOpts,
+		settings: backend.DataSourceInstanceSettings{
+			DecryptedSecureJSONData: map[string]string{"apiKey": "000000|invalid"},
+		},
+		config: datasourceSettings{HealthQuery: defaultHealthQuery, FlightAddress: defaultFlightAddress, DatasetsURL: defaultDatasetsURL},
+	}
+
+	res, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Status != backend.HealthStatusError {
+		t.Fatal("CheckHealth must report an error for an invalid API key")
+	}
+
+	var details healthDetails
+	if err := json.Unmarshal(res.JSONDetails, &details); err != nil {
+		t.Fatal(err)
+	}
+
+	if details.AuthStatus == "authenticated" {
+		t.Fatalf("wrong auth status, %v", details.AuthStatus)
+	}
+
+	if len(details.Stages) != 5 {
+		t.Fatalf("wrong number of stages, %v", len(details.Stages))
+	}
+
+	if details.Stages[0].Name != "settings" || details.Stages[0].Status != stageOk {
+		t.Fatalf("wrong settings stage, %v", details.Stages[0])
+	}
+}
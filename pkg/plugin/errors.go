@@ -0,0 +1,114 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const concurrentRequestLimitMessage = "Exceeded concurrent request limit"
+
+// classifyError maps an error returned while querying Spice to a Grafana
+// status and the source of the error. Errors carrying a gRPC status, network
+// errors and timeouts originate from Spice and are reported as downstream;
+// anything else is treated as a plugin error.
+func classifyError(err error) (backend.Status, backend.ErrorSource) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return backend.StatusTimeout, backend.ErrorSourceDownstream
+	}
+
+	if s, ok := status.FromError(err); ok {
+		return statusFromCode(s), backend.ErrorSourceDownstream
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return backend.StatusTimeout, backend.ErrorSourceDownstream
+		}
+		return backend.StatusBadGateway, backend.ErrorSourceDownstream
+	}
+
+	return backend.StatusInternal, backend.ErrorSourcePlugin
+}
+
+func statusFromCode(s *status.Status) backend.Status {
+	switch s.Code() {
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return backend.StatusBadRequest
+	case codes.Unauthenticated:
+		return backend.StatusUnauthorized
+	case codes.PermissionDenied:
+		return backend.StatusForbidden
+	case codes.NotFound:
+		return backend.StatusNotFound
+	case codes.ResourceExhausted:
+		return backend.StatusTooManyRequests
+	case codes.DeadlineExceeded:
+		return backend.StatusTimeout
+	case codes.Unavailable:
+		return backend.StatusBadGateway
+	case codes.Unimplemented:
+		return backend.StatusNotImplemented
+	case codes.Unknown:
+		// Spice reports its rate limit with an Unknown code
+		if strings.HasSuffix(s.Message(), concurrentRequestLimitMessage) {
+			return backend.StatusTooManyRequests
+		}
+	}
+
+	return backend.StatusInternal
+}
+
+// isConnectionError reports whether err means Spice could not be reached.
+func isConnectionError(err error) bool {
+	if status.Code(err) == codes.Unavailable {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// authStatus describes the outcome of authenticating with Spice given the
+// error returned by a query.
+func authStatus(err error) string {
+	switch {
+	case err == nil:
+		return "authenticated"
+	case status.Code(err) == codes.Unauthenticated:
+		return "unauthenticated"
+	case status.Code(err) == codes.PermissionDenied:
+		return "forbidden"
+	}
+	return "unknown"
+}
+
+// errorResponse builds an error DataResponse for err with its status and
+// error source set. DataFusion errors are returned in their structured form
+// along with a frame carrying them as a notice.
+func errorResponse(err error) backend.DataResponse {
+	errStatus, source := classifyError(err)
+
+	if sqlErr := parseSQLError(err); sqlErr != nil {
+		switch sqlErr.Kind {
+		case "parser", "planning", "schema":
+			errStatus = backend.StatusBadRequest
+		}
+
+		return backend.DataResponse{
+			Frames:      data.Frames{sqlErr.frame()},
+			Error:       sqlErr,
+			Status:      errStatus,
+			ErrorSource: source,
+		}
+	}
+
+	return backend.ErrDataResponseWithSource(errStatus, source, err.Error())
+}
@@ -0,0 +1,65 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status backend.Status
+		source backend.ErrorSource
+	}{
+		{"InvalidArgument", status.Error(codes.InvalidArgument, "bad sql"), backend.StatusBadRequest, backend.ErrorSourceDownstream},
+		{"Unauthenticated", status.Error(codes.Unauthenticated, "bad key"), backend.StatusUnauthorized, backend.ErrorSourceDownstream},
+		{"PermissionDenied", status.Error(codes.PermissionDenied, "denied"), backend.StatusForbidden, backend.ErrorSourceDownstream},
+		{"NotFound", status.Error(codes.NotFound, "no table"), backend.StatusNotFound, backend.ErrorSourceDownstream},
+		{"ResourceExhausted", status.Error(codes.ResourceExhausted, "quota"), backend.StatusTooManyRequests, backend.ErrorSourceDownstream},
+		{"DeadlineExceeded", status.Error(codes.DeadlineExceeded, "slow"), backend.StatusTimeout, backend.ErrorSourceDownstream},
+		{"Unavailable", status.Error(codes.Unavailable, "down"), backend.StatusBadGateway, backend.ErrorSourceDownstream},
+		{"concurrent request limit", status.Error(codes.Unknown, "Exceeded concurrent request limit"), backend.StatusTooManyRequests, backend.ErrorSourceDownstream},
+		{"wrapped status", fmt.Errorf("query: %w", status.Error(codes.Unauthenticated, "bad key")), backend.StatusUnauthorized, backend.ErrorSourceDownstream},
+		{"context deadline", fmt.Errorf("query: %w", context.DeadlineExceeded), backend.StatusTimeout, backend.ErrorSourceDownstream},
+		{"plain error", errors.New("boom"), backend.StatusInternal, backend.ErrorSourcePlugin},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, source := classifyError(tt.err)
+
+			if s != tt.status {
+				t.Fatalf("wrong status, %v %v", s, tt.status)
+			}
+
+			if source != tt.source {
+				t.Fatalf("wrong error source, %v %v", source, tt.source)
+			}
+		})
+	}
+}
+
+func TestQueryReaderError(t *testing.T) {
+	reader := &failingReader{
+		RecordReader: testRecordReader(t, []int64{1, 2}),
+		err:          status.Error(codes.Unavailable, "stream reset"),
+	}
+	ds := &Datasource{spice: &testSpiceClient{reader: reader}}
+
+	res := ds.query(context.Background(), backend.PluginContext{}, backend.DataQuery{
+		RefID: "A",
+		JSON:  json.RawMessage(`{"queryText": "SELECT number FROM eth.blocks"}`),
+	})
+
+	if res.Error == nil || res.Status != backend.StatusBadGateway {
+		t.Fatalf("a failed read must not return a partial frame, %v %v", res.Status, res.Error)
+	}
+}
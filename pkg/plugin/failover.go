@@ -0,0 +1,135 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"github.com/apache/arrow/go/v14/arrow/array"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Names of the API keys
+const (
+	primaryKey   = "primary"
+	secondaryKey = "secondary"
+)
+
+// keyedClient is a Spice client authenticated with one of the API keys.
+type keyedClient struct {
+	name   string
+	apiKey string
+	client spiceClient
+}
+
+// failoverClient is a spiceClient over the primary and, when configured,
+// secondary API keys. Queries use the active key and fail over to the other
+// key when Spice rejects the active one as unauthenticated, so keys can be
+// rotated without editing the datasource.
+type failoverClient struct {
+	clients []keyedClient
+	active  atomic.Int32
+}
+
+func newFailoverClient(clients ...keyedClient) *failoverClient {
+	return &failoverClient{clients: clients}
+}
+
+func (c *failoverClient) Query(ctx context.Context, sql string) (array.RecordReader, error) {
+	return c.run(func(client spiceClient) (array.RecordReader, error) {
+		return client.Query(ctx, sql)
+	})
+}
+
+func (c *failoverClient) FireQuery(ctx context.Context, sql string) (array.RecordReader, error) {
+	return c.run(func(client spiceClient) (array.RecordReader, error) {
+		return client.FireQuery(ctx, sql)
+	})
+}
+
+// run runs query with the active client, retrying with the other client
+// when the active key is unauthenticated.
+func (c *failoverClient) run(query func(client spiceClient) (array.RecordReader, error)) (array.RecordReader, error) {
+	return failover(c, func(k keyedClient) (array.RecordReader, error) {
+		return query(k.client)
+	}, isUnauthenticated)
+}
+
+// failover runs call with the active key, retrying with the other key when
+// rejected reports the error as a rejection of the active key. The other key
+// becomes active when it succeeds.
+func failover[T any](c *failoverClient, call func(k keyedClient) (T, error), rejected func(err error) bool) (T, error) {
+	active := int(c.active.Load())
+
+	result, err := call(c.clients[active])
+	if len(c.clients) < 2 || err == nil || !rejected(err) {
+		return result, err
+	}
+
+	next := (active + 1) % len(c.clients)
+
+	result, err = call(c.clients[next])
+	if err == nil {
+		c.activate(active, next)
+	}
+	return result, err
+}
+
+// isUnauthenticated reports whether Spice rejected the API key of a Flight
+// call.
+func isUnauthenticated(err error) bool {
+	return status.Code(err) == codes.Unauthenticated
+}
+
+// activate makes the client at index next active, unless another query
+// already switched keys.
+func (c *failoverClient) activate(active int, next int) {
+	if c.active.CompareAndSwap(int32(active), int32(next)) {
+		log.DefaultLogger.Warn("Spice API key failover", "from", c.clients[active].name, "to", c.clients[next].name)
+	}
+}
+
+// activeClient returns the client of the active API key.
+func (c *failoverClient) activeClient() keyedClient {
+	return c.clients[c.active.Load()]
+}
+
+// handshake authenticates the client of each API key in turn, starting with
+// the active one, until one succeeds; that key becomes active. It returns the
+// error of the active key when none succeeds.
+func (c *failoverClient) handshake(authenticate func(k keyedClient) error) error {
+	active := int(c.active.Load())
+
+	var firstErr error
+	for i := range c.clients {
+		next := (active + i) % len(c.clients)
+
+		err := authenticate(c.clients[next])
+		if err == nil {
+			if next != active {
+				c.activate(active, next)
+			}
+			return nil
+		}
+
+		if firstErr == nil {
+			firstErr = err
+		}
+
+		if !isUnauthenticated(err) {
+			return err
+		}
+	}
+
+	return firstErr
+}
+
+func (c *failoverClient) Close() error {
+	var errs []error
+	for _, client := range c.clients {
+		errs = append(errs, client.client.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,104 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/apache/arrow/go/v14/arrow/array"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// testSpiceClient is a spiceClient returning a fixed error, counting the
+// queries it ran.
+type testSpiceClient struct {
+	err     error
+	reader  array.RecordReader
+	queries int
+}
+
+func (c *testSpiceClient) Query(ctx context.Context, sql string) (array.RecordReader, error) {
+	c.queries++
+	return c.reader, c.err
+}
+
+func (c *testSpiceClient) FireQuery(ctx context.Context, sql string) (array.RecordReader, error) {
+	return c.Query(ctx, sql)
+}
+
+func (c *testSpiceClient) Close() error {
+	return nil
+}
+
+func TestFailoverClient(t *testing.T) {
+	unauthenticated := status.Error(codes.Unauthenticated, "invalid api key")
+
+	t.Run("fails over on unauthenticated", func(t *testing.T) {
+		primary := &testSpiceClient{err: unauthenticated}
+		secondary := &testSpiceClient{}
+		c := newFailoverClient(
+			keyedClient{name: primaryKey, apiKey: "1|primary", client: primary},
+			keyedClient{name: secondaryKey, apiKey: "1|secondary", client: secondary})
+
+		if _, err := c.Query(context.Background(), "SELECT 1"); err != nil {
+			t.Fatal(err)
+		}
+
+		if c.activeClient().name != secondaryKey {
+			t.Fatalf("secondary key must be active, %v", c.activeClient().name)
+		}
+
+		if _, err := c.FireQuery(context.Background(), "SELECT 1"); err != nil || primary.queries != 1 || secondary.queries != 2 {
+			t.Fatalf("queries must use the active key, %v %v %v", err, primary.queries, secondary.queries)
+		}
+	})
+
+	t.Run("keeps key on other errors", func(t *testing.T) {
+		primary := &testSpiceClient{err: status.Error(codes.InvalidArgument, "bad query")}
+		secondary := &testSpiceClient{}
+		c := newFailoverClient(
+			keyedClient{name: primaryKey, client: primary},
+			keyedClient{name: secondaryKey, client: secondary})
+
+		if _, err := c.Query(context.Background(), "SELECT"); status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("query error must be returned, %v", err)
+		}
+
+		if c.activeClient().name != primaryKey || secondary.queries != 0 {
+			t.Fatal("primary key must stay active")
+		}
+	})
+
+	t.Run("without secondary", func(t *testing.T) {
+		c := newFailoverClient(keyedClient{name: primaryKey, client: &testSpiceClient{err: unauthenticated}})
+
+		if _, err := c.Query(context.Background(), "SELECT 1"); status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("unauthenticated error must be returned, %v", err)
+		}
+	})
+
+	t.Run("handshake", func(t *testing.T) {
+		c := newFailoverClient(
+			keyedClient{name: primaryKey, apiKey: "1|primary", client: &testSpiceClient{}},
+			keyedClient{name: secondaryKey, apiKey: "1|secondary", client: &testSpiceClient{}})
+
+		err := c.handshake(func(k keyedClient) error {
+			if k.apiKey != "1|secondary" {
+				return unauthenticated
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if c.activeClient().name != secondaryKey {
+			t.Fatalf("secondary key must be active, %v", c.activeClient().name)
+		}
+
+		err = c.handshake(func(k keyedClient) error { return unauthenticated })
+		if status.Code(err) != codes.Unauthenticated || c.activeClient().name != secondaryKey {
+			t.Fatalf("failed handshake must keep the active key, %v", err)
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// defaultHeaders are sent on every HTTP request to Spice unless configured
+// otherwise in the datasource HTTP settings.
+var defaultHeaders = map[string]string{
+	"Content-Type":    "application/json",
+	"Accept-Encoding": "gzip, deflate",
+}
+
+// apiKeyHeader carries the active API key on HTTP requests to Spice. It is
+// set on each request, so a key failover applies to the HTTP calls too.
+const apiKeyHeader = "X-Api-Key"
+
+// mergeHeaders merges the custom headers configured in the datasource HTTP
+// settings with the default headers. Custom headers override the defaults,
+// but never the API key.
+func mergeHeaders(custom map[string]string) map[string]string {
+	headers := map[string]string{}
+
+	for key, value := range defaultHeaders {
+		headers[key] = value
+	}
+
+	for key, value := range custom {
+		headers[http.CanonicalHeaderKey(key)] = value
+	}
+
+	delete(headers, apiKeyHeader)
+	return headers
+}
+
+// customMetadata returns the custom headers configured in the datasource HTTP
+// settings as the gRPC metadata of the Flight calls. Headers reserved by gRPC
+// or the Flight authentication are skipped.
+func customMetadata(custom map[string]string) metadata.MD {
+	md := metadata.MD{}
+
+	for key, value := range custom {
+		key = strings.ToLower(key)
+
+		switch {
+		case key == "authorization", key == "content-type", key == "te", key == "user-agent",
+			strings.HasPrefix(key, "grpc-"), strings.HasPrefix(key, ":"):
+			continue
+		}
+
+		md.Set(key, value)
+	}
+
+	return md
+}
+
+// appendMetadata adds md to the outgoing gRPC metadata of ctx.
+func appendMetadata(ctx context.Context, md metadata.MD) context.Context {
+	if len(md) == 0 {
+		return ctx
+	}
+
+	pairs := make([]string, 0, 2*len(md))
+	for key, values := range md {
+		for _, value := range values {
+			pairs = append(pairs, key, value)
+		}
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, pairs...)
+}
@@ -0,0 +1,45 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestMergeHeaders(t *testing.T) {
+	headers := mergeHeaders(map[string]string{
+		"x-tenant":        "acme",
+		"Accept-Encoding": "gzip",
+		"X-API-KEY":       "other",
+	})
+
+	if headers["X-Tenant"] != "acme" {
+		t.Fatalf("custom header must be kept, %v", headers)
+	}
+
+	if headers["Accept-Encoding"] != "gzip" || headers["Content-Type"] != "application/json" {
+		t.Fatalf("custom headers must override the defaults, %v", headers)
+	}
+
+	if _, ok := headers[apiKeyHeader]; ok || len(headers) != 3 {
+		t.Fatalf("api key must not be overridden, %v", headers)
+	}
+}
+
+func TestCustomMetadata(t *testing.T) {
+	md := customMetadata(map[string]string{
+		"X-Tenant":      "acme",
+		"Authorization": "Bearer other",
+		"grpc-timeout":  "1S",
+	})
+
+	if len(md) != 1 || md.Get("x-tenant")[0] != "acme" {
+		t.Fatalf("wrong metadata, %v", md)
+	}
+
+	outgoing, _ := metadata.FromOutgoingContext(appendMetadata(context.Background(), md))
+	if v := outgoing.Get("x-tenant"); len(v) != 1 || v[0] != "acme" {
+		t.Fatalf("metadata must be sent, %v", outgoing)
+	}
+}
@@ -0,0 +1,214 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/v14/arrow/flight"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+const healthStageTimeout = 10 * time.Second
+
+const (
+	stageOk      = "ok"
+	stageError   = "error"
+	stageSkipped = "skipped"
+)
+
+// healthCheck is a single stage of CheckHealth. A stage is skipped when the
+// stage it depends on did not succeed, or with the reason skip when it is
+// set.
+type healthCheck struct {
+	name      string
+	dependsOn string
+	skip      string
+	run       func(ctx context.Context) (string, error)
+}
+
+// CheckHealth handles health checks sent from Grafana to the plugin.
+// The main use case for these health checks is the test button on the
+// datasource configuration page which allows users to verify that
+// a datasource is working as expected.
+//
+// The check runs in stages (settings, HTTP reachability, Flight handshake,
+// health query and dataset listing) which are reported separately in the
+// result details, so a bad API key can be told apart from a blocked network.
+func (d *Datasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
+	var status = backend.HealthStatusOk
+	var message = "Data source is working"
+
+	details := healthDetails{
+		Endpoint:   d.config.FlightAddress,
+		Query:      d.config.HealthQuery,
+		AuthStatus: "unknown",
+	}
+
+	// Self-hosted runtimes have no datasets API unless its URL is set.
+	var skipDatasets string
+	if d.config.DatasetsURL == "" {
+		skipDatasets = errNoDatasetsURL.Error()
+	}
+
+	checks := []healthCheck{
+		{name: "settings", run: d.checkSettings},
+		{name: "http", dependsOn: "settings", skip: skipDatasets, run: d.checkHTTP},
+		{name: "flight", dependsOn: "settings", run: func(ctx context.Context) (string, error) {
+			err := d.checkFlight(ctx)
+			details.Reached = err == nil || !isConnectionError(err)
+			details.AuthStatus = authStatus(err)
+			details.ActiveKey = d.activeAPIKey().name
+			return fmt.Sprintf("%s API key", details.ActiveKey), err
+		}},
+		{name: "query", dependsOn: "flight", run: func(ctx context.Context) (string, error) {
+			start := time.Now()
+			rows, err := d.healthQuery(ctx)
+			details.LatencyMs = time.Since(start).Milliseconds()
+			details.Rows = rows
+			return fmt.Sprintf("%d rows", rows), err
+		}},
+		{name: "datasets", dependsOn: "http", skip: skipDatasets, run: func(ctx context.Context) (string, error) {
+			datasets, err := d.fetchDatasets(ctx)
+			details.Datasets = len(datasets)
+			return fmt.Sprintf("%d datasets", len(datasets)), err
+		}},
+	}
+
+	results := map[string]string{}
+
+	for _, check := range checks {
+		stage := healthStage{Name: check.name, Status: stageOk}
+
+		if check.skip != "" {
+			stage.Status = stageSkipped
+			stage.Message = check.skip
+		} else if check.dependsOn != "" && results[check.dependsOn] != stageOk {
+			stage.Status = stageSkipped
+			stage.Message = fmt.Sprintf("%s check did not succeed", check.dependsOn)
+		} else {
+			stageCtx, cancel := context.WithTimeout(ctx, healthStageTimeout)
+			start := time.Now()
+			msg, err := check.run(stageCtx)
+			stage.DurationMs = time.Since(start).Milliseconds()
+			cancel()
+
+			stage.Message = msg
+			if err != nil {
+				stage.Status = stageError
+				stage.Message = err.Error()
+
+				if status == backend.HealthStatusOk {
+					status = backend.HealthStatusError
+					message = fmt.Sprintf("%s check failed: %v", check.name, err.Error())
+				}
+			}
+		}
+
+		results[check.name] = stage.Status
+		details.Stages = append(details.Stages, stage)
+	}
+
+	if status == backend.HealthStatusOk && len(d.config.warnings) > 0 {
+		message = fmt.Sprintf("%s, but %s", message, strings.Join(d.config.warnings, "; "))
+	}
+
+	jsonDetails, err := json.Marshal(details)
+	if err != nil {
+		return nil, err
+	}
+
+	return &backend.CheckHealthResult{
+		Status:      status,
+		Message:     message,
+		JSONDetails: jsonDetails,
+	}, nil
+}
+
+func (d *Datasource) checkSettings(_ context.Context) (string, error) {
+	if d.apiKey() == "" {
+		return "", fmt.Errorf("missing Spice AI apiKey")
+	}
+
+	if d.config.HealthQuery == "" {
+		return "", fmt.Errorf("missing health query")
+	}
+
+	return strings.Join(d.config.warnings, "; "), nil
+}
+
+// checkHTTP verifies the datasets API can be reached. Any HTTP response counts
+// as reachable; authorization is verified by the datasets stage.
+func (d *Datasource) checkHTTP(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.config.DatasetsURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	return fmt.Sprintf("HTTP %s", res.Status), nil
+}
+
+// checkFlight performs a Flight handshake with the active API key. Clients
+// dialed by the plugin authenticate on the connection used by the queries;
+// gospice exposes no handshake, so the Flight endpoint is dialed as gospice
+// does. When Spice rejects the active key, the other key is tried and becomes
+// active if it succeeds.
+func (d *Datasource) checkFlight(ctx context.Context) error {
+	ctx = appendMetadata(ctx, d.metadata)
+
+	var dialed flight.Client
+	defer func() {
+		if dialed != nil {
+			dialed.Close()
+		}
+	}()
+
+	authenticate := func(k keyedClient) error {
+		if client, ok := k.client.(*flightClient); ok {
+			return client.handshake(ctx)
+		}
+
+		if dialed == nil {
+			client, err := flight.NewClientWithMiddlewareCtx(ctx, d.config.FlightAddress, nil, nil, d.dialOpts...)
+			if err != nil {
+				return err
+			}
+			dialed = client
+		}
+
+		appID := strings.Split(k.apiKey, "|")[0]
+		_, err := dialed.AuthenticateBasicToken(ctx, appID, k.apiKey)
+		return err
+	}
+
+	if f, ok := d.spice.(*failoverClient); ok {
+		return f.handshake(authenticate)
+	}
+	return authenticate(keyedClient{name: primaryKey, apiKey: d.apiKey(), client: d.spice})
+}
+
+// healthQuery runs the configured health query and returns the number of
+// rows it produced.
+func (d *Datasource) healthQuery(ctx context.Context) (int64, error) {
+	reader, err := d.SpiceQuery(ctx, d.config.HealthQuery, "")
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Release()
+
+	var rows int64
+	for reader.Next() {
+		rows += reader.Record().NumRows()
+	}
+
+	return rows, reader.Err()
+}
@@ -0,0 +1,118 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"google.golang.org/grpc/metadata"
+)
+
+// Headers forwarding the OAuth identity of the signed-in user. The API key
+// already authenticates the plugin with the Authorization header, so the
+// user's token is forwarded under its own header.
+const (
+	forwardedAuthorizationHeader = "X-Forwarded-Authorization"
+	forwardedIDTokenHeader       = "X-Id-Token"
+)
+
+// Values of the user header
+const (
+	userHeaderLogin = "login"
+	userHeaderEmail = "email"
+)
+
+type identityKey struct{}
+
+// userIdentity returns the headers identifying the signed-in user to Spice,
+// according to the identity forwarding settings. headers are the HTTP headers
+// Grafana forwarded with the request.
+func (d *Datasource) userIdentity(pCtx backend.PluginContext, headers http.Header) http.Header {
+	identity := http.Header{}
+
+	if d.config.OAuthPassThru {
+		if token := headers.Get(backend.OAuthIdentityTokenHeaderName); token != "" {
+			identity.Set(forwardedAuthorizationHeader, token)
+		}
+		if idToken := headers.Get(backend.OAuthIdentityIDTokenHeaderName); idToken != "" {
+			identity.Set(forwardedIDTokenHeader, idToken)
+		}
+	}
+
+	if d.config.UserHeader != "" && pCtx.User != nil {
+		value := pCtx.User.Login
+		if d.config.UserHeaderValue == userHeaderEmail {
+			value = pCtx.User.Email
+		}
+		if value != "" {
+			identity.Set(d.config.UserHeader, value)
+		}
+	}
+
+	return identity
+}
+
+// withUserIdentity returns ctx carrying the identity of the signed-in user,
+// which SpiceQuery sends as gRPC metadata and the resource handlers as HTTP
+// headers.
+func (d *Datasource) withUserIdentity(ctx context.Context, pCtx backend.PluginContext, headers http.Header) context.Context {
+	identity := d.userIdentity(pCtx, headers)
+	if len(identity) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+func identityFromContext(ctx context.Context) http.Header {
+	identity, _ := ctx.Value(identityKey{}).(http.Header)
+	return identity
+}
+
+// identityMetadata adds the user identity of ctx to the outgoing gRPC
+// metadata.
+func identityMetadata(ctx context.Context) context.Context {
+	identity := identityFromContext(ctx)
+	if len(identity) == 0 {
+		return ctx
+	}
+
+	pairs := make([]string, 0, 2*len(identity))
+	for key, values := range identity {
+		pairs = append(pairs, strings.ToLower(key), values[0])
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, pairs...)
+}
+
+// setIdentityHeaders adds the user identity of ctx to the headers of req.
+func setIdentityHeaders(ctx context.Context, req *http.Request) {
+	for key, values := range identityFromContext(ctx) {
+		req.Header.Set(key, values[0])
+	}
+}
+
+// identityCacheKey scopes key to the user identity of ctx, so results cached
+// for one user are not served to another when identities are forwarded.
+func identityCacheKey(ctx context.Context, key string) string {
+	identity := identityFromContext(ctx)
+	if len(identity) == 0 {
+		return key
+	}
+
+	keys := make([]string, 0, len(identity))
+	for k := range identity {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	for _, k := range keys {
+		hash.Write([]byte(k + "=" + identity.Get(k) + "\n"))
+	}
+
+	return key + "|" + hex.EncodeToString(hash.Sum(nil)[:8])
+}
@@ -0,0 +1,55 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestUserIdentity(t *testing.T) {
+	pCtx := backend.PluginContext{User: &backend.User{Login: "admin", Email: "admin@example.com"}}
+	headers := http.Header{}
+	headers.Set(backend.OAuthIdentityTokenHeaderName, "Bearer token")
+
+	t.Run("disabled", func(t *testing.T) {
+		ds := &Datasource{}
+
+		ctx := ds.withUserIdentity(context.Background(), pCtx, headers)
+		if identityFromContext(ctx) != nil {
+			t.Fatal("identity must not be forwarded by default")
+		}
+	})
+
+	t.Run("oauth and user header", func(t *testing.T) {
+		ds := &Datasource{config: datasourceSettings{OAuthPassThru: true, UserHeader: "X-Grafana-User", UserHeaderValue: userHeaderEmail}}
+
+		ctx := ds.withUserIdentity(context.Background(), pCtx, headers)
+
+		md, _ := metadata.FromOutgoingContext(identityMetadata(ctx))
+		if v := md.Get("x-forwarded-authorization"); len(v) != 1 || v[0] != "Bearer token" {
+			t.Fatalf("oauth token must be forwarded, %v", md)
+		}
+		if v := md.Get("x-grafana-user"); len(v) != 1 || v[0] != "admin@example.com" {
+			t.Fatalf("user email must be forwarded, %v", md)
+		}
+
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, defaultDatasetsURL, nil)
+		setIdentityHeaders(ctx, req)
+		if req.Header.Get("X-Grafana-User") != "admin@example.com" {
+			t.Fatalf("user header must be set, %v", req.Header)
+		}
+
+		if identityCacheKey(ctx, "key") == identityCacheKey(context.Background(), "key") {
+			t.Fatal("cache key must be scoped to the user")
+		}
+	})
+
+	t.Run("reserved header", func(t *testing.T) {
+		if _, err := loadSettings(backend.DataSourceInstanceSettings{JSONData: []byte(`{"userHeader":"x-api-key"}`)}); err == nil {
+			t.Fatal("user header must not replace the api key")
+		}
+	})
+}
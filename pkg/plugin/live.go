@@ -0,0 +1,428 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/live"
+)
+
+const (
+	livePathPrefix      = "live/"
+	defaultLiveInterval = 5 * time.Second
+	minLiveInterval     = time.Second
+)
+
+// errNoLiveTimeColumn is reported for live queries without a time column,
+// whose new rows cannot be told apart from those already sent.
+var errNoLiveTimeColumn = errors.New("live updates need a time column")
+
+// liveQuery is a query streamed over a Grafana Live channel, along with the
+// watermark of the rows already sent to subscribers, the user identity the
+// channel is scoped to and the schema of the frames sent so far. Channels
+// which must be subscribed before a deadline have an expiry time.
+type liveQuery struct {
+	query     spiceQuery
+	watermark *liveWatermark
+	identity  string
+	schema    *data.Frame
+	expires   time.Time
+}
+
+// liveQueries registers the queries of the live channels handled by the
+// datasource, keyed by channel path. Grafana runs a single RunStream loop per
+// channel, so every panel subscribed to the same query shares one poller.
+type liveQueries struct {
+	mu      sync.Mutex
+	queries map[string]*liveQuery
+}
+
+func (l *liveQueries) register(path string, q spiceQuery, watermark *liveWatermark, identity string) {
+	l.add(path, &liveQuery{query: q, watermark: watermark, identity: identity})
+}
+
+// registerOnce registers a channel which must be subscribed within ttl, and
+// is removed when its stream starts.
+func (l *liveQueries) registerOnce(path string, q spiceQuery, identity string, ttl time.Duration) {
+	l.add(path, &liveQuery{query: q, identity: identity, expires: time.Now().Add(ttl)})
+}
+
+// add registers lq under path, removing the channels whose subscription
+// deadline has passed.
+func (l *liveQueries) add(path string, lq *liveQuery) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.queries == nil {
+		l.queries = map[string]*liveQuery{}
+	}
+
+	now := time.Now()
+	for p, q := range l.queries {
+		if !q.expires.IsZero() && now.After(q.expires) {
+			delete(l.queries, p)
+		}
+	}
+
+	// A channel registered again keeps the schema sent by its running stream.
+	if existing, ok := l.queries[path]; ok {
+		lq.schema = existing.schema
+	}
+
+	l.queries[path] = lq
+}
+
+// remove unregisters the channel path when it still holds lq, so a query
+// registered again meanwhile is kept.
+func (l *liveQueries) remove(path string, lq *liveQuery) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.queries[path] == lq {
+		delete(l.queries, path)
+	}
+}
+
+// setSchema records the schema of frame, the first frame sent on the channel
+// path, for the subscribers joining after it was sent.
+func (l *liveQueries) setSchema(path string, frame *data.Frame) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if lq, ok := l.queries[path]; ok {
+		lq.schema = frame.EmptyCopy()
+	}
+}
+
+// initialData returns the schema of the frames already sent on the channel
+// of lq as subscription initial data, or nil before the first frame.
+func (l *liveQueries) initialData(lq *liveQuery) (*backend.InitialData, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if lq.schema == nil {
+		return nil, nil
+	}
+	return backend.NewInitialFrame(lq.schema, data.IncludeSchemaOnly)
+}
+
+func (l *liveQueries) get(path string) (*liveQuery, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	q, ok := l.queries[path]
+	return q, ok
+}
+
+// queryHash identifies a query by its SQL, source, filters, live interval,
+// time column and format.
+func queryHash(q spiceQuery) string {
+	b, _ := json.Marshal([]interface{}{q.QueryText, q.QuerySource, q.AdhocFilters, q.LiveInterval, q.TimeColumn, q.Format})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:16])
+}
+
+// channelPath returns the path of the channel of q for the user identity:
+// the query hash, hashed along with the identity when there is one.
+func channelPath(prefix string, q spiceQuery, identity string) string {
+	if identity == "" {
+		return prefix + queryHash(q)
+	}
+
+	sum := sha256.Sum256([]byte(queryHash(q) + "\x00" + identity))
+	return prefix + hex.EncodeToString(sum[:16])
+}
+
+// channelIdentity returns the user identity the channels of pCtx are scoped
+// to, so users only receive results of queries run with their own identity.
+// It is empty when no user identity is forwarded to Spice.
+func (d *Datasource) channelIdentity(pCtx backend.PluginContext) string {
+	if d.config.UserHeader == "" {
+		return ""
+	}
+	return d.userIdentity(pCtx, nil).Get(d.config.UserHeader)
+}
+
+// liveChannel registers q as a live query and returns the Grafana Live
+// channel streaming its new rows. watermark is the latest time already
+// returned for the query, so the stream only sends newer rows.
+func (d *Datasource) liveChannel(pCtx backend.PluginContext, q spiceQuery, watermark *liveWatermark) string {
+	return d.registerChannel(pCtx, livePathPrefix, q, watermark)
+}
+
+// registerChannel registers q under the channel path prefix followed by the
+// hash of the query and user identity, and returns the full Grafana Live
+// channel.
+func (d *Datasource) registerChannel(pCtx backend.PluginContext, prefix string, q spiceQuery, watermark *liveWatermark) string {
+	identity := d.channelIdentity(pCtx)
+	path := channelPath(prefix, q, identity)
+	d.live.register(path, q, watermark, identity)
+
+	return d.channel(path)
+}
+
+// channel returns the Grafana Live channel of the datasource path.
+func (d *Datasource) channel(path string) string {
+	return live.Channel{
+		Scope:     live.ScopeDatasource,
+		Namespace: d.settings.UID,
+		Path:      path,
+	}.String()
+}
+
+// SubscribeStream accepts subscriptions to registered live query channels
+// scoped to the identity of the subscriber. Subscribers of live queries may
+// also send the query in the request data, which registers the channel when
+// the plugin was restarted since the query ran.
+func (d *Datasource) SubscribeStream(ctx context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+	prefix, ok := channelPrefix(req.Path)
+	if !ok {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+	}
+
+	if d.config.OAuthPassThru {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusPermissionDenied}, nil
+	}
+
+	identity := d.channelIdentity(req.PluginContext)
+
+	if _, ok := d.live.get(req.Path); !ok && prefix == livePathPrefix && len(req.Data) > 0 {
+		q := spiceQuery{}
+		if err := json.Unmarshal(req.Data, &q); err == nil && channelPath(prefix, q, identity) == req.Path {
+			d.live.register(req.Path, q, nil, identity)
+		}
+	}
+
+	lq, ok := d.live.get(req.Path)
+	if !ok {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+	}
+
+	if lq.identity != identity {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusPermissionDenied}, nil
+	}
+
+	// Frames after the first are sent without their schema, which subscribers
+	// joining a running stream receive as initial data.
+	initialData, err := d.live.initialData(lq)
+	if err != nil {
+		return nil, err
+	}
+
+	return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusOK, InitialData: initialData}, nil
+}
+
+// channelPrefix returns the prefix of a channel path handled by the
+// datasource.
+func channelPrefix(path string) (string, bool) {
+	for _, prefix := range []string{livePathPrefix, streamPathPrefix} {
+		if strings.HasPrefix(path, prefix) {
+			return prefix, true
+		}
+	}
+	return "", false
+}
+
+// PublishStream rejects publications: live channels only carry query results.
+func (d *Datasource) PublishStream(ctx context.Context, req *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
+	return &backend.PublishStreamResponse{Status: backend.PublishStreamStatusPermissionDenied}, nil
+}
+
+// RunStream polls Spice for rows newer than the channel watermark and sends
+// them to the subscribers until the last one leaves. Queries without a time
+// column have no watermark, so their stream stops after the first rows
+// rather than sending their full result on every poll.
+//
+// Partial result channels instead run their query once, sending each record
+// batch as it arrives. Queries are sent with the identity of the user the
+// channel is scoped to.
+func (d *Datasource) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
+	lq, ok := d.live.get(req.Path)
+	if !ok {
+		return fmt.Errorf("live channel %s not found", req.Path)
+	}
+
+	if d.config.OAuthPassThru || lq.identity != d.channelIdentity(req.PluginContext) {
+		return fmt.Errorf("live channel %s is not available to this user", req.Path)
+	}
+
+	ctx = d.withUserIdentity(ctx, req.PluginContext, nil)
+
+	// Partial result channels belong to a single query execution, so they
+	// are unregistered as soon as their stream starts.
+	if strings.HasPrefix(req.Path, streamPathPrefix) {
+		d.live.remove(req.Path, lq)
+		return d.runPartialStream(ctx, req.PluginContext, req.Path, lq.query, sender)
+	}
+
+	// The channel is registered again by the next query or subscription.
+	defer d.live.remove(req.Path, lq)
+
+	interval := defaultLiveInterval
+	if lq.query.LiveInterval != "" {
+		if parsed, err := time.ParseDuration(lq.query.LiveInterval); err == nil && parsed >= minLiveInterval {
+			interval = parsed
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	watermark := lq.watermark
+	schemaSent := false
+	logger := d.logger(ctx).With("path", req.Path)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-ticker.C:
+			frame, err := d.pollLiveQuery(ctx, req.PluginContext, lq.query, watermark)
+			if err != nil {
+				logger.Error("Live query failed", "error", d.redact(err.Error()))
+				continue
+			}
+
+			if frame.Rows() == 0 {
+				continue
+			}
+
+			w, hasWatermark := frameWatermark(frame, lq.query.TimeColumn)
+			if hasWatermark {
+				watermark = w
+			}
+
+			frame, err = shapeTimeFrame(frame, lq.query.TimeColumn)
+			if err != nil {
+				logger.Error("Live query failed", "error", d.redact(err.Error()))
+				continue
+			}
+
+			if !hasWatermark {
+				logger.Warn("Live query has no time column, stopping live updates")
+				frame.AppendNotices(data.Notice{Severity: data.NoticeSeverityWarning, Text: errNoLiveTimeColumn.Error()})
+			}
+
+			include := data.IncludeDataOnly
+			if !schemaSent {
+				include = data.IncludeAll
+				d.live.setSchema(req.Path, frame)
+			}
+
+			if err := sender.SendFrame(frame, include); err != nil {
+				return err
+			}
+			schemaSent = true
+
+			if !hasWatermark {
+				return nil
+			}
+		}
+	}
+}
+
+// pollLiveQuery runs q, restricted to the rows after watermark when there is
+// one. Every poll is audited.
+func (d *Datasource) pollLiveQuery(ctx context.Context, pCtx backend.PluginContext, q spiceQuery, watermark *liveWatermark) (*data.Frame, error) {
+	sql, err := d.expandQuery(ctx, pCtx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	sql = watermark.sql(sql)
+	start := time.Now()
+
+	reader, err := d.SpiceQuery(ctx, sql, q.QuerySource)
+	if err != nil {
+		d.auditQuery(ctx, pCtx, q.QuerySource, sql, start, 0, err)
+		return nil, err
+	}
+	defer reader.Release()
+
+	frame, stats := recordsToFrame(ctx, reader)
+	d.auditQuery(ctx, pCtx, q.QuerySource, sql, start, stats.rows, reader.Err())
+	if err := reader.Err(); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// liveWatermark is the latest value of the time column of a live query
+// already sent to the subscribers.
+type liveWatermark struct {
+	column string
+	value  interface{}
+}
+
+// sql wraps sql to only return rows whose time column is after the
+// watermark. A nil watermark returns sql unchanged.
+func (w *liveWatermark) sql(sql string) string {
+	if w == nil {
+		return sql
+	}
+
+	literal := fmt.Sprint(w.value)
+	if t, ok := w.value.(time.Time); ok {
+		literal = fmt.Sprintf("CAST(%s AS TIMESTAMP)", quoteLiteral(t.UTC().Format(time.RFC3339Nano)))
+	}
+
+	return fmt.Sprintf("SELECT * FROM (\n%s\n) AS live WHERE %s > %s", trimSQL(sql), quoteColumn(w.column), literal)
+}
+
+// frameWatermark returns the latest value of the time field of frame, as
+// found by timeFieldIndex. Integer epochs are returned as int64.
+func frameWatermark(frame *data.Frame, timeColumn string) (*liveWatermark, bool) {
+	index := timeFieldIndex(frame, timeColumn)
+	if index < 0 {
+		return nil, false
+	}
+
+	field := frame.Fields[index]
+	var latest interface{}
+
+	for i := 0; i < field.Len(); i++ {
+		v, ok := field.ConcreteAt(i)
+		if !ok {
+			continue
+		}
+
+		var epoch int64
+		switch v := v.(type) {
+		case time.Time:
+			if latest == nil || v.After(latest.(time.Time)) {
+				latest = v
+			}
+			continue
+		case int64:
+			epoch = v
+		case int32:
+			epoch = int64(v)
+		case uint32:
+			epoch = int64(v)
+		case uint64:
+			epoch = int64(v)
+		default:
+			continue
+		}
+
+		if latest == nil || epoch > latest.(int64) {
+			latest = epoch
+		}
+	}
+
+	if latest == nil {
+		return nil, false
+	}
+
+	return &liveWatermark{column: field.Name, value: latest}, true
+}
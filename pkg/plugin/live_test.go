@@ -0,0 +1,220 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestFrameWatermark(t *testing.T) {
+	t.Run("time field", func(t *testing.T) {
+		latest := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+		frame := data.NewFrame("response",
+			data.NewField("number", nil, []int64{1, 2}),
+			data.NewField("ts", nil, []time.Time{latest, latest.Add(-time.Hour)}))
+
+		w, ok := frameWatermark(frame, "")
+		if !ok || w.column != "ts" || w.value != latest {
+			t.Fatalf("wrong watermark, %v", w)
+		}
+
+		expected := "SELECT * FROM (\nSELECT * FROM eth.recent_blocks -- latest\n) AS live WHERE " +
+			`"ts" > CAST('2024-01-02T00:00:00Z' AS TIMESTAMP)`
+		if sql := w.sql("SELECT * FROM eth.recent_blocks -- latest"); sql != expected {
+			t.Fatalf("wrong sql, %v", sql)
+		}
+	})
+
+	t.Run("epoch field", func(t *testing.T) {
+		frame := data.NewFrame("response",
+			data.NewField("number", nil, []int64{1, 2}),
+			data.NewField("block_timestamp", nil, []uint32{1700000000, 1700000012}))
+
+		w, ok := frameWatermark(frame, "")
+		if !ok || w.column != "block_timestamp" || w.value != int64(1700000012) {
+			t.Fatalf("wrong watermark, %v", w)
+		}
+	})
+
+	t.Run("time column override", func(t *testing.T) {
+		frame := data.NewFrame("response",
+			data.NewField("ts", nil, []time.Time{time.Now(), time.Now()}),
+			data.NewField("block_time", nil, []int64{1700000000, 1700000012}))
+
+		w, ok := frameWatermark(frame, "block_time")
+		if !ok || w.column != "block_time" || w.value != int64(1700000012) {
+			t.Fatalf("wrong watermark, %v", w)
+		}
+	})
+
+	t.Run("no time field", func(t *testing.T) {
+		frame := data.NewFrame("response", data.NewField("number", nil, []int64{1, 2}))
+
+		if _, ok := frameWatermark(frame, ""); ok {
+			t.Fatal("unexpected watermark")
+		}
+
+		var w *liveWatermark
+		if sql := w.sql("SELECT 1"); sql != "SELECT 1" {
+			t.Fatalf("wrong sql, %v", sql)
+		}
+	})
+}
+
+func TestSubscribeStream(t *testing.T) {
+	ds := &Datasource{settings: backend.DataSourceInstanceSettings{UID: "spice"}}
+	q := spiceQuery{QueryText: "SELECT * FROM eth.recent_blocks", QuerySource: "default", Live: true}
+
+	channel := ds.liveChannel(backend.PluginContext{}, q, nil)
+	if channel != "ds/spice/live/"+queryHash(q) {
+		t.Fatalf("wrong channel, %v", channel)
+	}
+
+	res, err := ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{Path: "live/" + queryHash(q)})
+	if err != nil || res.Status != backend.SubscribeStreamStatusOK {
+		t.Fatalf("registered channel must be subscribable, %v", err)
+	}
+
+	res, _ = ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{Path: "live/unknown"})
+	if res.Status != backend.SubscribeStreamStatusNotFound {
+		t.Fatal("unknown channel must not be found")
+	}
+
+	other := spiceQuery{QueryText: "SELECT 1"}
+	body, _ := json.Marshal(other)
+
+	res, _ = ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{Path: "live/" + queryHash(other), Data: body})
+	if res.Status != backend.SubscribeStreamStatusOK {
+		t.Fatal("channel must be registered from the subscription data")
+	}
+}
+
+func TestChannelIdentity(t *testing.T) {
+	ds := &Datasource{
+		settings: backend.DataSourceInstanceSettings{UID: "spice"},
+		config:   datasourceSettings{UserHeader: "X-Grafana-User"},
+	}
+	q := spiceQuery{QueryText: "SELECT * FROM eth.recent_blocks", Live: true}
+	alice := backend.PluginContext{User: &backend.User{Login: "alice"}}
+	bob := backend.PluginContext{User: &backend.User{Login: "bob"}}
+
+	path := strings.TrimPrefix(ds.liveChannel(alice, q, nil), "ds/spice/")
+	if path == channelPath(livePathPrefix, q, "bob") || path == channelPath(livePathPrefix, q, "") {
+		t.Fatal("channels must be scoped to the user identity")
+	}
+
+	res, _ := ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{PluginContext: alice, Path: path})
+	if res.Status != backend.SubscribeStreamStatusOK {
+		t.Fatal("user must subscribe to their channel")
+	}
+
+	res, _ = ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{PluginContext: bob, Path: path})
+	if res.Status != backend.SubscribeStreamStatusPermissionDenied {
+		t.Fatal("user must not subscribe to the channel of another user")
+	}
+
+	t.Run("oauth", func(t *testing.T) {
+		ds := &Datasource{config: datasourceSettings{OAuthPassThru: true}}
+
+		res := ds.query(context.Background(), alice, backend.DataQuery{
+			RefID: "A",
+			JSON:  json.RawMessage(`{"queryText": "SELECT 1", "live": true}`),
+		})
+		if res.Error == nil {
+			t.Fatal("live queries must be rejected when the OAuth identity is forwarded")
+		}
+	})
+}
+
+func TestLiveQueries(t *testing.T) {
+	t.Run("interval", func(t *testing.T) {
+		q := spiceQuery{QueryText: "SELECT 1", Live: true, LiveInterval: "1s"}
+		other := q
+		other.LiveInterval = "10s"
+
+		if queryHash(q) == queryHash(other) {
+			t.Fatal("queries with different live intervals must use different channels")
+		}
+
+		other = q
+		other.TimeColumn = "block_time"
+		if queryHash(q) == queryHash(other) {
+			t.Fatal("queries with different time columns must use different channels")
+		}
+	})
+
+	t.Run("no time column", func(t *testing.T) {
+		ds := &Datasource{
+			settings: backend.DataSourceInstanceSettings{UID: "spice"},
+			spice:    &testSpiceClient{reader: testRecordReader(t, []int64{1, 2})},
+		}
+
+		res := ds.query(context.Background(), backend.PluginContext{}, backend.DataQuery{
+			RefID: "A",
+			JSON:  json.RawMessage(`{"queryText": "SELECT number FROM eth.blocks", "live": true}`),
+		})
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+
+		meta := res.Frames[0].Meta
+		if meta.Channel != "" || len(meta.Notices) != 1 {
+			t.Fatalf("queries without a time column must not be live, %v %v", meta.Channel, meta.Notices)
+		}
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		l := liveQueries{}
+		l.register("live/a", spiceQuery{}, nil, "")
+		lq, _ := l.get("live/a")
+
+		l.register("live/a", spiceQuery{}, nil, "")
+		l.remove("live/a", lq)
+		if _, ok := l.get("live/a"); !ok {
+			t.Fatal("query registered again must be kept")
+		}
+
+		lq, _ = l.get("live/a")
+		l.remove("live/a", lq)
+		if _, ok := l.get("live/a"); ok {
+			t.Fatal("query must be removed")
+		}
+	})
+}
+
+func TestLiveInitialData(t *testing.T) {
+	ds := &Datasource{settings: backend.DataSourceInstanceSettings{UID: "spice"}}
+	q := spiceQuery{QueryText: "SELECT * FROM eth.blocks", Live: true}
+	ds.liveChannel(backend.PluginContext{}, q, nil)
+
+	req := &backend.SubscribeStreamRequest{Path: "live/" + queryHash(q)}
+
+	res, err := ds.SubscribeStream(context.Background(), req)
+	if err != nil || res.InitialData != nil {
+		t.Fatalf("channel without frames must have no initial data, %v", err)
+	}
+
+	ds.live.setSchema(req.Path, data.NewFrame("response", data.NewField("number", nil, []int64{1, 2})))
+
+	// the schema is kept when the query runs again while streaming
+	ds.liveChannel(backend.PluginContext{}, q, nil)
+
+	res, err = ds.SubscribeStream(context.Background(), req)
+	if err != nil || res.InitialData == nil {
+		t.Fatalf("late subscribers must receive the schema, %v", err)
+	}
+
+	frame := &data.Frame{}
+	if err := json.Unmarshal(res.InitialData.Data(), frame); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(frame.Fields) != 1 || frame.Fields[0].Name != "number" || frame.Rows() != 0 {
+		t.Fatalf("initial data must only hold the schema, %v", frame.Fields)
+	}
+}
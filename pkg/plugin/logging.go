@@ -0,0 +1,55 @@
+package plugin
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const redacted = "[REDACTED]"
+
+var (
+	sqlStringLiteral  = regexp.MustCompile(`'(?:[^']|'')*'`)
+	sqlNumericLiteral = regexp.MustCompile(`\b\d+(?:\.\d+)?\b`)
+)
+
+// logger returns the context logger, which carries the datasource, user and
+// trace attributes set by the plugin SDK, with the datasource UID.
+func (d *Datasource) logger(ctx context.Context) log.Logger {
+	return log.DefaultLogger.FromContext(ctx).With("datasourceUID", d.settings.UID)
+}
+
+// queryLogger returns the logger of a single data query.
+func (d *Datasource) queryLogger(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery) log.Logger {
+	params := []interface{}{"refID", query.RefID, "orgID", pCtx.OrgID}
+	if pCtx.User != nil {
+		params = append(params, "user", pCtx.User.Login)
+	}
+	return d.logger(ctx).With(params...)
+}
+
+// redact replaces the API keys and their secret part in msg, so errors
+// echoing the credentials sent to Spice can be logged.
+func (d *Datasource) redact(msg string) string {
+	for _, apiKey := range []string{d.apiKey(), d.secondaryAPIKey()} {
+		if apiKey == "" {
+			continue
+		}
+
+		msg = strings.ReplaceAll(msg, apiKey, redacted)
+		if _, secret, ok := strings.Cut(apiKey, "|"); ok && secret != "" {
+			msg = strings.ReplaceAll(msg, secret, redacted)
+		}
+	}
+	return msg
+}
+
+// redactSQL replaces the string and numeric literals of sql, which hold the
+// filter and variable values interpolated into the query, with placeholders.
+func redactSQL(sql string) string {
+	sql = sqlStringLiteral.ReplaceAllString(sql, "?")
+	return sqlNumericLiteral.ReplaceAllString(sql, "?")
+}
@@ -0,0 +1,59 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestRedact(t *testing.T) {
+	ds := &Datasource{settings: backend.DataSourceInstanceSettings{
+		DecryptedSecureJSONData: map[string]string{"apiKey": "313834|0666ecb5f8"},
+	}}
+
+	if msg := ds.redact("auth failed for 313834|0666ecb5f8"); msg != "auth failed for [REDACTED]" {
+		t.Fatalf("api key must be redacted, %v", msg)
+	}
+
+	if msg := ds.redact("invalid token 0666ecb5f8"); msg != "invalid token [REDACTED]" {
+		t.Fatalf("api key secret must be redacted, %v", msg)
+	}
+}
+
+func TestRedactSQL(t *testing.T) {
+	sql := redactSQL(`SELECT * FROM eth.recent_blocks WHERE "miner" = 'o''brien' AND number > 42.5 LIMIT 10`)
+	if sql != `SELECT * FROM eth.recent_blocks WHERE "miner" = ? AND number > ? LIMIT ?` {
+		t.Fatalf("wrong redacted sql, %v", sql)
+	}
+}
+
+func TestLoadSettingsSlowQuery(t *testing.T) {
+	s, err := loadSettings(backend.DataSourceInstanceSettings{JSONData: []byte(`{"slowQueryThreshold":"2s"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.slowQuery != 2*time.Second {
+		t.Fatalf("wrong slow query threshold, %v", s.slowQuery)
+	}
+
+	s, err = loadSettings(backend.DataSourceInstanceSettings{JSONData: []byte(`{"slowQueryThreshold":"fast"}`)})
+	if err != nil {
+		t.Fatalf("invalid slow query threshold must not fail the datasource, %v", err)
+	}
+
+	if s.slowQuery != 0 || len(s.warnings) != 1 {
+		t.Fatalf("invalid slow query threshold must be ignored with a warning, %v %v", s.slowQuery, s.warnings)
+	}
+
+	ds := &Datasource{
+		settings: backend.DataSourceInstanceSettings{DecryptedSecureJSONData: map[string]string{"apiKey": "000000|invalid"}},
+		config:   s,
+	}
+	if msg, err := ds.checkSettings(context.Background()); err != nil || !strings.Contains(msg, "slow query threshold") {
+		t.Fatalf("settings check must report the warning, %q %v", msg, err)
+	}
+}
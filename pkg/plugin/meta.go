@@ -0,0 +1,81 @@
+package plugin
+
+import (
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// queryStats are collected while running a query and reading its results.
+type queryStats struct {
+	batches int64
+	rows    int64
+	bytes   int64
+	elapsed time.Duration
+
+	// upstream is the time spent waiting on Spice and conversion the time
+	// spent converting record batches to frames.
+	upstream   time.Duration
+	conversion time.Duration
+
+	// firstBatch is the time from running the query to its first record
+	// batch.
+	firstBatch time.Duration
+}
+
+// frameStats returns the stats shown in Query Inspector. The Spice client
+// does not expose the Flight response metadata, so only the timings measured
+// by the plugin are reported.
+func (s queryStats) frameStats(querySource string) []data.QueryStat {
+	firecache := 0.0
+	if querySource == "firecache" {
+		firecache = 1
+	}
+
+	return []data.QueryStat{
+		{FieldConfig: data.FieldConfig{DisplayName: "Rows read"}, Value: float64(s.rows)},
+		{FieldConfig: data.FieldConfig{DisplayName: "Bytes read", Unit: "decbytes"}, Value: float64(s.bytes)},
+		{FieldConfig: data.FieldConfig{DisplayName: "Record batches"}, Value: float64(s.batches)},
+		{FieldConfig: data.FieldConfig{DisplayName: "Elapsed time", Unit: "ms"}, Value: float64(s.elapsed.Milliseconds())},
+		{FieldConfig: data.FieldConfig{DisplayName: "Time to first batch", Unit: "ms"}, Value: milliseconds(s.firstBatch)},
+		{FieldConfig: data.FieldConfig{DisplayName: "Stream time", Unit: "ms"}, Value: milliseconds(s.upstream)},
+		{FieldConfig: data.FieldConfig{DisplayName: "Conversion time", Unit: "ms"}, Value: milliseconds(s.conversion)},
+		{FieldConfig: data.FieldConfig{DisplayName: "Served by firecache", Unit: "bool"}, Value: firecache},
+	}
+}
+
+// milliseconds returns d in milliseconds, keeping sub-millisecond precision.
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// setFrameMeta sets the dataplane metadata of frame: its type, the executed
+// SQL, the query source and stats. A type already set by the conversion, such
+// as numeric or time series, is kept.
+func setFrameMeta(frame *data.Frame, sql string, querySource string, stats queryStats) {
+	if frame.Meta == nil {
+		frame.Meta = &data.FrameMeta{}
+	}
+
+	meta := frame.Meta
+
+	if meta.Type == data.FrameTypeUnknown {
+		meta.Type = data.FrameTypeTable
+		meta.TypeVersion = data.FrameTypeVersion{0, 0}
+	}
+
+	meta.PreferredVisualization = data.VisTypeTable
+	if meta.Type == data.FrameTypeTimeSeriesWide {
+		meta.PreferredVisualization = data.VisTypeGraph
+	}
+
+	if querySource == "" {
+		querySource = "default"
+	}
+
+	meta.ExecutedQueryString = sql
+	meta.Custom = map[string]interface{}{
+		"querySource": querySource,
+	}
+	meta.Stats = append(meta.Stats, stats.frameStats(querySource)...)
+}
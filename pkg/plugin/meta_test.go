@@ -0,0 +1,104 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v14/arrow"
+	"github.com/apache/arrow/go/v14/arrow/array"
+	"github.com/apache/arrow/go/v14/arrow/memory"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func testRecordReader(t *testing.T, batches ...[]int64) array.RecordReader {
+	schema := arrow.NewSchema([]arrow.Field{{Name: "number", Type: arrow.PrimitiveTypes.Int64}}, nil)
+	pool := memory.NewGoAllocator()
+
+	records := []arrow.Record{}
+	for _, values := range batches {
+		builder := array.NewRecordBuilder(pool, schema)
+		builder.Field(0).(*array.Int64Builder).AppendValues(values, nil)
+		records = append(records, builder.NewRecord())
+		builder.Release()
+	}
+
+	reader, err := array.NewRecordReader(schema, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return reader
+}
+
+// failingReader is a record reader which fails after its record batches.
+type failingReader struct {
+	array.RecordReader
+	err error
+}
+
+func (r *failingReader) Err() error {
+	return r.err
+}
+
+func TestRecordsToFrameStats(t *testing.T) {
+	reader := testRecordReader(t, []int64{1, 2}, []int64{3})
+	defer reader.Release()
+
+	frame, stats := recordsToFrame(context.Background(), reader)
+
+	if frame.Rows() != 3 {
+		t.Fatalf("wrong number of rows, %v", frame.Rows())
+	}
+
+	if stats.batches != 2 || stats.rows != 3 || stats.bytes == 0 {
+		t.Fatalf("wrong stats, %+v", stats)
+	}
+
+	if stats.firstBatch > stats.upstream+stats.conversion {
+		t.Fatalf("first batch must arrive within the stream time, %+v", stats)
+	}
+}
+
+func TestSetFrameMeta(t *testing.T) {
+	t.Run("table", func(t *testing.T) {
+		frame := data.NewFrame("response", data.NewField("number", nil, []int64{1}))
+
+		setFrameMeta(frame, "SELECT 1", "", queryStats{rows: 1, elapsed: time.Second})
+
+		if frame.Meta.Type != data.FrameTypeTable || frame.Meta.PreferredVisualization != data.VisTypeTable {
+			t.Fatalf("wrong type, %v %v", frame.Meta.Type, frame.Meta.PreferredVisualization)
+		}
+
+		if frame.Meta.ExecutedQueryString != "SELECT 1" {
+			t.Fatalf("wrong executed query, %v", frame.Meta.ExecutedQueryString)
+		}
+
+		if frame.Meta.Custom.(map[string]interface{})["querySource"] != "default" {
+			t.Fatalf("wrong query source, %v", frame.Meta.Custom)
+		}
+
+		if len(frame.Meta.Stats) != 8 || frame.Meta.Stats[3].Value != 1000 {
+			t.Fatalf("wrong stats, %v", frame.Meta.Stats)
+		}
+
+		if frame.Meta.Stats[7].Value != 0 {
+			t.Fatalf("default engine must not be reported as firecache, %v", frame.Meta.Stats[7])
+		}
+	})
+
+	t.Run("keeps conversion type", func(t *testing.T) {
+		frame := data.NewFrame("response")
+		frame.SetMeta(&data.FrameMeta{Type: data.FrameTypeTimeSeriesWide, TypeVersion: data.FrameTypeVersion{0, 1}})
+
+		setFrameMeta(frame, "SELECT 1", "firecache", queryStats{})
+
+		if frame.Meta.Type != data.FrameTypeTimeSeriesWide || frame.Meta.PreferredVisualization != data.VisTypeGraph {
+			t.Fatalf("wrong type, %v %v", frame.Meta.Type, frame.Meta.PreferredVisualization)
+		}
+
+		if frame.Meta.Stats[7].Value != 1 {
+			t.Fatalf("firecache must be reported, %v", frame.Meta.Stats[7])
+		}
+	})
+}
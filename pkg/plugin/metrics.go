@@ -0,0 +1,145 @@
+package plugin
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics are registered with the default Prometheus registry, which the
+// plugin SDK exposes on the plugin metrics endpoint scraped by Grafana.
+const (
+	metricsNamespace = "plugins"
+	metricsSubsystem = "spice"
+)
+
+var (
+	queriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "queries_total",
+		Help:      "Number of queries run, by query source and status.",
+	}, []string{"source", "status"})
+
+	queryErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "query_errors_total",
+		Help:      "Number of failed queries, by query source, status and error source.",
+	}, []string{"source", "status", "error_source"})
+
+	queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "query_duration_seconds",
+		Help:      "Duration of queries from parsing to the built response, by query source.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+	}, []string{"source"})
+
+	upstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "upstream_duration_seconds",
+		Help:      "Time spent waiting on Spice for query results, by query source.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+	}, []string{"source"})
+
+	conversionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "conversion_duration_seconds",
+		Help:      "Time spent converting record batches to data frames, by query source.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"source"})
+
+	rowsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "rows_total",
+		Help:      "Number of rows converted to data frames, by query source.",
+	}, []string{"source"})
+
+	bytesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "bytes_total",
+		Help:      "Size of the record batches converted to data frames, by query source.",
+	}, []string{"source"})
+
+	concurrentQueries = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "concurrent_queries",
+		Help:      "Number of queries currently running.",
+	})
+
+	cacheRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "cache_requests_total",
+		Help:      "Number of cache lookups, by cache and result.",
+	}, []string{"cache", "result"})
+)
+
+// queryObserver records the metrics of a single query. The query source and
+// stats are filled in as the query runs.
+type queryObserver struct {
+	start  time.Time
+	source string
+	stats  queryStats
+}
+
+// observeQuery counts a query as running until done is called.
+func observeQuery() *queryObserver {
+	concurrentQueries.Inc()
+	return &queryObserver{start: time.Now(), source: "default"}
+}
+
+// setSource sets the query source label, keeping the default for an empty
+// source.
+func (o *queryObserver) setSource(source string) {
+	if source != "" {
+		o.source = source
+	}
+}
+
+// done records the outcome of the query from its response.
+func (o *queryObserver) done(res backend.DataResponse) {
+	concurrentQueries.Dec()
+
+	status := responseStatus(res)
+	if res.Error != nil {
+		queryErrorsTotal.WithLabelValues(o.source, status, string(res.ErrorSource)).Inc()
+	}
+
+	queriesTotal.WithLabelValues(o.source, status).Inc()
+	queryDuration.WithLabelValues(o.source).Observe(time.Since(o.start).Seconds())
+
+	if o.stats.upstream > 0 {
+		upstreamDuration.WithLabelValues(o.source).Observe(o.stats.upstream.Seconds())
+		conversionDuration.WithLabelValues(o.source).Observe(o.stats.conversion.Seconds())
+		rowsTotal.WithLabelValues(o.source).Add(float64(o.stats.rows))
+		bytesTotal.WithLabelValues(o.source).Add(float64(o.stats.bytes))
+	}
+}
+
+// responseStatus returns "ok" for a successful response, otherwise its status
+// code.
+func responseStatus(res backend.DataResponse) string {
+	if res.Error == nil {
+		return "ok"
+	}
+	return strconv.Itoa(int(res.Status))
+}
+
+// observeCacheLookup counts a lookup in the named cache.
+func observeCacheLookup(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheRequestsTotal.WithLabelValues(cache, result).Inc()
+}
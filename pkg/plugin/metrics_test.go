@@ -0,0 +1,81 @@
+package plugin
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metricValue returns the value of the counter or gauge named name with the
+// given label values from the default registry.
+func metricValue(t *testing.T, name string, labels ...string) float64 {
+	t.Helper()
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+
+	metrics:
+		for _, m := range family.GetMetric() {
+			if len(m.GetLabel()) != len(labels) {
+				continue
+			}
+			for i, label := range m.GetLabel() {
+				if label.GetValue() != labels[i] {
+					continue metrics
+				}
+			}
+
+			if m.GetGauge() != nil {
+				return m.GetGauge().GetValue()
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+
+	return 0
+}
+
+func TestQueryObserver(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		before := metricValue(t, "plugins_spice_queries_total", "firecache", "ok")
+
+		observer := observeQuery()
+		observer.setSource("firecache")
+		observer.stats = queryStats{rows: 3, upstream: 1}
+		observer.done(backend.DataResponse{})
+
+		if got := metricValue(t, "plugins_spice_queries_total", "firecache", "ok"); got != before+1 {
+			t.Fatalf("query must be counted, %v", got)
+		}
+
+		if got := metricValue(t, "plugins_spice_concurrent_queries"); got != 0 {
+			t.Fatalf("finished query must not be running, %v", got)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		labels := []string{string(backend.ErrorSourceDownstream), "default", "400"}
+		before := metricValue(t, "plugins_spice_query_errors_total", labels...)
+
+		observer := observeQuery()
+		observer.setSource("")
+		observer.done(backend.DataResponse{
+			Error:       errors.New("bad query"),
+			Status:      backend.StatusBadRequest,
+			ErrorSource: backend.ErrorSourceDownstream,
+		})
+
+		if got := metricValue(t, "plugins_spice_query_errors_total", labels...); got != before+1 {
+			t.Fatalf("error must be counted by class, %v", got)
+		}
+	})
+}
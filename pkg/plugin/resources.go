@@ -0,0 +1,115 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// newResourceHandler returns the router serving the datasource resources.
+// Resources are exposed by Grafana under
+// /api/datasources/uid/{uid}/resources/{path}.
+func (d *Datasource) newResourceHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/datasets", allowMethods(d.handleDatasets, http.MethodGet))
+	mux.HandleFunc("/datasets/", d.handleDataset)
+	mux.HandleFunc("/variables", allowMethods(d.handleVariables, http.MethodPost))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("resource %s not found", r.URL.Path))
+	})
+	return mux
+}
+
+// handleDataset routes the /datasets/{name}/{resource} resources.
+func (d *Datasource) handleDataset(w http.ResponseWriter, r *http.Request) {
+	name, resource, ok := datasetResourcePath(r.URL.Path)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("resource %s not found", r.URL.Path))
+		return
+	}
+
+	switch resource {
+	case "schema":
+		allowMethods(func(w http.ResponseWriter, r *http.Request) {
+			d.handleSchema(w, r, name)
+		}, http.MethodGet)(w, r)
+
+	case "tag-keys":
+		allowMethods(func(w http.ResponseWriter, r *http.Request) {
+			d.handleTagKeys(w, r, name)
+		}, http.MethodGet)(w, r)
+
+	case "tag-values":
+		allowMethods(func(w http.ResponseWriter, r *http.Request) {
+			d.handleTagValues(w, r, name)
+		}, http.MethodGet)(w, r)
+
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("dataset resource %q not found", resource))
+	}
+}
+
+// datasetResourcePath splits a /datasets/{name}/{resource} path.
+func datasetResourcePath(path string) (string, string, bool) {
+	rest := strings.TrimPrefix(path, "/datasets/")
+
+	i := strings.LastIndex(rest, "/")
+	if i <= 0 || i == len(rest)-1 {
+		return "", "", false
+	}
+
+	return rest[:i], rest[i+1:], true
+}
+
+// allowMethods rejects requests whose method is not one of methods.
+func allowMethods(h http.HandlerFunc, methods ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, method := range methods {
+			if r.Method == method {
+				h(w, r)
+				return
+			}
+		}
+
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// writeJSON writes v as a JSON response.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if _, err := w.Write(body); err != nil {
+		log.DefaultLogger.Error("write resource response", "error", err)
+	}
+}
+
+// writeError writes err as a JSON error response.
+func writeError(w http.ResponseWriter, status int, err error) {
+	body, _ := json.Marshal(map[string]string{
+		"error": err.Error(),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if _, err := w.Write(body); err != nil {
+		log.DefaultLogger.Error("write resource response", "error", err)
+	}
+}
+
+// queryErrorStatus maps an error returned by Spice to an HTTP status code.
+func queryErrorStatus(err error) int {
+	return int(errorResponse(err).Status)
+}
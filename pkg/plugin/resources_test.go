@@ -0,0 +1,88 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+)
+
+type resourceSender struct {
+	res *backend.CallResourceResponse
+}
+
+func (s *resourceSender) Send(res *backend.CallResourceResponse) error {
+	s.res = res
+	return nil
+}
+
+func TestResourceRouter(t *testing.T) {
+	ds := &Datasource{}
+	router := ds.newResourceHandler()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+	}{
+		{"unknown resource", http.MethodGet, "/unknown", http.StatusNotFound},
+		{"datasets method", http.MethodPost, "/datasets", http.StatusMethodNotAllowed},
+		{"schema method", http.MethodDelete, "/datasets/eth.recent_blocks/schema", http.StatusMethodNotAllowed},
+		{"unknown dataset resource", http.MethodGet, "/datasets/eth.recent_blocks/unknown", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if res.Code != tt.status {
+				t.Fatalf("wrong status, %v %v", res.Code, tt.status)
+			}
+
+			var body map[string]string
+			if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil || body["error"] == "" {
+				t.Fatalf("missing JSON error body, %s", res.Body)
+			}
+		})
+	}
+}
+
+func TestDatasetResourcePath(t *testing.T) {
+	name, resource, ok := datasetResourcePath("/datasets/eth.recent_blocks/schema")
+	if !ok || name != "eth.recent_blocks" || resource != "schema" {
+		t.Fatalf("wrong path, %v %v", name, resource)
+	}
+
+	if _, _, ok := datasetResourcePath("/datasets/eth.recent_blocks"); ok {
+		t.Fatal("path without resource must not match")
+	}
+
+	if _, _, ok := datasetResourcePath("/datasets//schema"); ok {
+		t.Fatal("path without dataset name must not match")
+	}
+}
+
+func TestCallResource(t *testing.T) {
+	ds := &Datasource{}
+	ds.resourceHandler = httpadapter.New(ds.newResourceHandler())
+
+	sender := &resourceSender{}
+	err := ds.CallResource(context.Background(), &backend.CallResourceRequest{
+		Method: http.MethodGet,
+		Path:   "unknown",
+		URL:    "unknown",
+	}, sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res := sender.res; res.Status != http.StatusNotFound {
+		t.Fatalf("wrong status, %v", res.Status)
+	}
+}
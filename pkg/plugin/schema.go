@@ -0,0 +1,193 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/v14/arrow"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+)
+
+const maxTagValues = 1000
+
+// datasetSchema describes the columns of a dataset.
+type datasetSchema struct {
+	Name        string         `json:"name"`
+	Columns     []schemaColumn `json:"columns"`
+	TimeColumns []string       `json:"timeColumns"`
+}
+
+type schemaColumn struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable"`
+	IsTime   bool   `json:"isTime"`
+}
+
+// fetchSchema discovers the schema of a dataset by running a LIMIT 0 query
+// against it.
+func (d *Datasource) fetchSchema(ctx context.Context, name string) (*datasetSchema, error) {
+	if name == "" {
+		return nil, fmt.Errorf("missing dataset name")
+	}
+
+	sql := fmt.Sprintf("SELECT * FROM %s LIMIT 0", quoteIdentifier(name))
+	start := time.Now()
+
+	reader, err := d.SpiceQuery(ctx, sql, "")
+	d.auditQuery(ctx, httpadapter.PluginConfigFromContext(ctx), "", sql, start, 0, err)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Release()
+
+	schema := &datasetSchema{
+		Name:        name,
+		Columns:     []schemaColumn{},
+		TimeColumns: []string{},
+	}
+
+	for _, field := range reader.Schema().Fields() {
+		column := schemaColumn{
+			Name:     field.Name,
+			Type:     field.Type.String(),
+			Nullable: field.Nullable,
+			IsTime:   isTimeColumn(field),
+		}
+
+		if column.IsTime {
+			schema.TimeColumns = append(schema.TimeColumns, field.Name)
+		}
+
+		schema.Columns = append(schema.Columns, column)
+	}
+
+	return schema, nil
+}
+
+// adhocColumnTypes returns the column types of the result of q when one of
+// its ad-hoc filters compares values, so they can be cast to the column type.
+// The types are looked up by running q with LIMIT 0. A failed lookup returns
+// no types, as the query itself then reports the error.
+func (d *Datasource) adhocColumnTypes(ctx context.Context, pCtx backend.PluginContext, q spiceQuery) map[string]arrow.DataType {
+	compares := false
+	for _, filter := range q.AdhocFilters {
+		compares = compares || !isPatternOperator(filter.Operator)
+	}
+	if !compares {
+		return nil
+	}
+
+	sql := fmt.Sprintf("SELECT * FROM (\n%s\n) AS adhoc_columns LIMIT 0", trimSQL(q.QueryText))
+	start := time.Now()
+
+	reader, err := d.SpiceQuery(ctx, sql, q.QuerySource)
+	d.auditQuery(ctx, pCtx, q.QuerySource, sql, start, 0, err)
+	if err != nil {
+		return nil
+	}
+	defer reader.Release()
+
+	types := map[string]arrow.DataType{}
+	for _, field := range reader.Schema().Fields() {
+		types[field.Name] = field.Type
+	}
+	return types
+}
+
+// expandQuery returns the SQL of q with its ad-hoc filters applied.
+func (d *Datasource) expandQuery(ctx context.Context, pCtx backend.PluginContext, q spiceQuery) (string, error) {
+	return applyAdhocFilters(q.QueryText, q.AdhocFilters, d.adhocColumnTypes(ctx, pCtx, q))
+}
+
+// isTimeColumn reports whether field holds time values, either as a temporal
+// Arrow type or as an integer column named like a Unix timestamp.
+func isTimeColumn(field arrow.Field) bool {
+	switch field.Type.ID() {
+	case arrow.TIMESTAMP, arrow.DATE32, arrow.DATE64:
+		return true
+	case arrow.INT32, arrow.INT64, arrow.UINT32, arrow.UINT64:
+		return isTimeColumnName(field.Name)
+	}
+	return false
+}
+
+// isTimeColumnName reports whether an integer column named name is expected
+// to hold Unix timestamps.
+func isTimeColumnName(name string) bool {
+	name = strings.ToLower(name)
+	return name == "timestamp" || strings.HasSuffix(name, "_timestamp")
+}
+
+// handleSchema serves the schema of the dataset name.
+func (d *Datasource) handleSchema(w http.ResponseWriter, r *http.Request, name string) {
+	schema, err := d.fetchSchema(r.Context(), name)
+	if err != nil {
+		writeError(w, queryErrorStatus(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, schema)
+}
+
+// handleTagKeys serves the columns of the dataset name as ad-hoc filter keys.
+func (d *Datasource) handleTagKeys(w http.ResponseWriter, r *http.Request, name string) {
+	schema, err := d.fetchSchema(r.Context(), name)
+	if err != nil {
+		writeError(w, queryErrorStatus(err), err)
+		return
+	}
+
+	keys := make([]variableValue, 0, len(schema.Columns))
+	for _, column := range schema.Columns {
+		keys = append(keys, variableValue{Text: column.Name, Value: column.Name})
+	}
+
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// handleTagValues serves the distinct values of the column given by the key
+// query parameter as ad-hoc filter values.
+func (d *Datasource) handleTagValues(w http.ResponseWriter, r *http.Request, name string) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("missing key parameter"))
+		return
+	}
+
+	cacheKey := identityCacheKey(r.Context(), "tag-values\x00"+name+"\x00"+key)
+	if values, ok := d.variablesCache.get(cacheKey); ok {
+		writeJSON(w, http.StatusOK, values)
+		return
+	}
+
+	column := quoteColumn(key)
+	sql := fmt.Sprintf("SELECT DISTINCT %s FROM %s WHERE %s IS NOT NULL ORDER BY 1 LIMIT %d",
+		column, quoteIdentifier(name), column, maxTagValues)
+	pCtx := httpadapter.PluginConfigFromContext(r.Context())
+	start := time.Now()
+
+	reader, err := d.SpiceQuery(r.Context(), sql, "")
+	if err != nil {
+		d.auditQuery(r.Context(), pCtx, "", sql, start, 0, err)
+		writeError(w, queryErrorStatus(err), err)
+		return
+	}
+	defer reader.Release()
+
+	frame, stats := recordsToFrame(r.Context(), reader)
+	d.auditQuery(r.Context(), pCtx, "", sql, start, stats.rows, reader.Err())
+	if err := reader.Err(); err != nil {
+		writeError(w, queryErrorStatus(err), err)
+		return
+	}
+
+	values := variableValues(frame)
+
+	d.variablesCache.set(cacheKey, values)
+	writeJSON(w, http.StatusOK, values)
+}
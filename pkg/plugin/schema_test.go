@@ -0,0 +1,30 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/apache/arrow/go/v14/arrow"
+)
+
+func TestIsTimeColumn(t *testing.T) {
+	tests := []struct {
+		field  arrow.Field
+		isTime bool
+	}{
+		{arrow.Field{Name: "ts", Type: &arrow.TimestampType{Unit: arrow.Second}}, true},
+		{arrow.Field{Name: "day", Type: arrow.FixedWidthTypes.Date32}, true},
+		{arrow.Field{Name: "block_timestamp", Type: arrow.PrimitiveTypes.Int64}, true},
+		{arrow.Field{Name: "timestamp", Type: arrow.PrimitiveTypes.Uint64}, true},
+		{arrow.Field{Name: "number", Type: arrow.PrimitiveTypes.Int64}, false},
+		{arrow.Field{Name: "exec_time", Type: arrow.PrimitiveTypes.Int64}, false},
+		{arrow.Field{Name: "timestamp", Type: arrow.BinaryTypes.String}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.field.Name+" "+tt.field.Type.String(), func(t *testing.T) {
+			if isTimeColumn(tt.field) != tt.isTime {
+				t.Fatalf("wrong result, expected %v", tt.isTime)
+			}
+		})
+	}
+}
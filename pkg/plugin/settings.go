@@ -0,0 +1,146 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const (
+	defaultFlightAddress    = "flight.spiceai.io:443"
+	defaultFirecacheAddress = "firecache.spiceai.io:443"
+	defaultDatasetsURL      = "https://data.spiceai.io/v0.1/datasets"
+	defaultHealthQuery      = "SELECT 1"
+)
+
+// datasourceSettings holds the non-secret datasource configuration stored in
+// the instance JSON data.
+type datasourceSettings struct {
+	// HealthQuery is run by CheckHealth to verify the connection.
+	HealthQuery string `json:"healthQuery"`
+
+	// FlightAddress and FirecacheAddress are the host:port of the Spice Flight
+	// endpoints, for self-hosted runtimes. They default to Spice Cloud.
+	FlightAddress    string `json:"flightAddress"`
+	FirecacheAddress string `json:"firecacheAddress"`
+
+	// DatasetsURL is the URL of the Spice datasets API. It defaults to Spice
+	// Cloud when FlightAddress does, and is otherwise disabled unless set, as
+	// self-hosted runtimes have no datasets API.
+	DatasetsURL string `json:"datasetsUrl"`
+
+	// ServerName overrides the name verified in the TLS certificate of the
+	// Spice endpoints.
+	ServerName string `json:"serverName"`
+
+	// SlowQueryThreshold enables the slow query log: queries running longer
+	// than this duration, such as "5s", are logged. Empty disables it.
+	SlowQueryThreshold string `json:"slowQueryThreshold"`
+
+	// AuditSink enables the audit log of executed queries: stdout, file or
+	// webhook. Empty disables it.
+	AuditSink       string `json:"auditSink"`
+	AuditPath       string `json:"auditPath"`
+	AuditWebhookURL string `json:"auditWebhookUrl"`
+
+	// AuditSampleRate is the fraction of queries written to the audit log,
+	// between 0 and 1. Unset logs every query; 0 logs none.
+	AuditSampleRate *float64 `json:"auditSampleRate"`
+
+	// OAuthPassThru forwards the OAuth token of the signed-in user to Spice.
+	OAuthPassThru bool `json:"oauthPassThru"`
+
+	// UserHeader is the header sent to Spice with the login, or the email
+	// when UserHeaderValue is "email", of the signed-in user. Empty disables
+	// it.
+	UserHeader      string `json:"userHeader"`
+	UserHeaderValue string `json:"userHeaderValue"`
+
+	slowQuery time.Duration
+
+	// warnings describe invalid optional settings that were ignored, which
+	// CheckHealth reports.
+	warnings []string
+}
+
+func loadSettings(settings backend.DataSourceInstanceSettings) (datasourceSettings, error) {
+	s := datasourceSettings{}
+
+	if len(settings.JSONData) > 0 {
+		if err := json.Unmarshal(settings.JSONData, &s); err != nil {
+			return s, fmt.Errorf("json unmarshal settings: %w", err)
+		}
+	}
+
+	if s.HealthQuery == "" {
+		s.HealthQuery = defaultHealthQuery
+	}
+
+	if s.FlightAddress == "" {
+		s.FlightAddress = defaultFlightAddress
+	}
+
+	if s.DatasetsURL == "" && s.FlightAddress == defaultFlightAddress {
+		s.DatasetsURL = defaultDatasetsURL
+	}
+
+	if s.FirecacheAddress == "" {
+		s.FirecacheAddress = defaultFirecacheAddress
+	}
+
+	switch http.CanonicalHeaderKey(s.UserHeader) {
+	case "Authorization", "X-Api-Key":
+		return s, fmt.Errorf("user header %s would replace the Spice credentials", s.UserHeader)
+	}
+
+	if s.SlowQueryThreshold != "" {
+		threshold, err := time.ParseDuration(s.SlowQueryThreshold)
+		if err != nil || threshold <= 0 {
+			warning := fmt.Sprintf("invalid slow query threshold %q, slow query log disabled", s.SlowQueryThreshold)
+			log.DefaultLogger.Warn("Ignoring invalid setting", "datasourceUID", settings.UID, "warning", warning)
+			s.warnings = append(s.warnings, warning)
+		} else {
+			s.slowQuery = threshold
+		}
+	}
+
+	return s, nil
+}
+
+// applyTLSOptions adds the server name override to the TLS options of the
+// HTTP client options. The SDK only reads it along with a CA or client
+// certificate.
+func (s datasourceSettings) applyTLSOptions(opts *httpclient.Options) {
+	if s.ServerName == "" {
+		return
+	}
+
+	if opts.TLS == nil {
+		opts.TLS = &httpclient.TLSOptions{}
+	}
+	opts.TLS.ServerName = s.ServerName
+}
+
+// apiKey returns the Spice API key from the secure settings.
+func (d *Datasource) apiKey() string {
+	return d.settings.DecryptedSecureJSONData["apiKey"]
+}
+
+// secondaryAPIKey returns the Spice API key queries fail over to when the
+// primary key is rejected.
+func (d *Datasource) secondaryAPIKey() string {
+	return d.settings.DecryptedSecureJSONData["secondaryApiKey"]
+}
+
+// activeAPIKey returns the API key currently used by the queries.
+func (d *Datasource) activeAPIKey() keyedClient {
+	if f, ok := d.spice.(*failoverClient); ok {
+		return f.activeClient()
+	}
+	return keyedClient{name: primaryKey, apiKey: d.apiKey(), client: d.spice}
+}
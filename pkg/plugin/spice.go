@@ -0,0 +1,246 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/apache/arrow/go/v14/arrow/array"
+	"github.com/apache/arrow/go/v14/arrow/flight"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/proxy"
+	"github.com/spiceai/gospice/v4"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+)
+
+// authorizationHeader carries the bearer token returned by the Flight
+// handshake.
+const authorizationHeader = "authorization"
+
+// spiceClient runs SQL queries on Spice, against the default query engine or
+// firecache.
+type spiceClient interface {
+	Query(ctx context.Context, sql string) (array.RecordReader, error)
+	FireQuery(ctx context.Context, sql string) (array.RecordReader, error)
+	Close() error
+}
+
+// newSpiceClient creates the client of the Spice Flight endpoints. gospice is
+// used unless the settings configure TLS or the secure socks proxy, which
+// gospice does not support; the Flight connections are then dialed by the
+// plugin with the same transport as the HTTP client.
+func newSpiceClient(config datasourceSettings, apiKey string, opts httpclient.Options) (spiceClient, error) {
+	if opts.TLS == nil && !proxy.New(opts.ProxyOptions).SecureSocksProxyEnabled() {
+		client := gospice.NewSpiceClientWithAddress(config.FlightAddress, config.FirecacheAddress)
+		if err := client.Init(apiKey); err != nil {
+			return nil, fmt.Errorf("failed to initialize gospice: %w", err)
+		}
+		return client, nil
+	}
+
+	dialOpts, err := flightDialOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFlightClient(apiKey, config.FlightAddress, config.FirecacheAddress, dialOpts...)
+}
+
+// flightDialOptions returns the gRPC dial options of the Flight connections,
+// using the TLS options of the HTTP client: custom CA, client certificate,
+// server name and certificate verification. When the secure socks proxy is
+// enabled, as it is for Private Data Source Connect, connections are dialed
+// through it.
+func flightDialOptions(opts httpclient.Options) ([]grpc.DialOption, error) {
+	tlsConfig, err := httpclient.GetTLSConfig(opts)
+	if err != nil {
+		return nil, fmt.Errorf("tls config: %w", err)
+	}
+
+	dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))}
+
+	proxyClient := proxy.New(opts.ProxyOptions)
+	if !proxyClient.SecureSocksProxyEnabled() {
+		return dialOpts, nil
+	}
+
+	dialer, err := proxyClient.NewSecureSocksProxyContextDialer()
+	if err != nil {
+		return nil, fmt.Errorf("secure socks proxy dialer: %w", err)
+	}
+
+	proxyDialer, ok := dialer.(contextDialer)
+	if !ok {
+		return nil, errors.New("secure socks proxy dialer does not support contexts")
+	}
+
+	return append(dialOpts, grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		return proxyDialer.DialContext(ctx, "tcp", addr)
+	})), nil
+}
+
+type contextDialer interface {
+	DialContext(ctx context.Context, network string, addr string) (net.Conn, error)
+}
+
+// flightClient is a spiceClient connected to the Spice Flight endpoints with
+// the dial options of the plugin.
+type flightClient struct {
+	apiKey    string
+	flight    *flightEndpoint
+	firecache *flightEndpoint
+}
+
+// flightEndpoint is the client of a Flight endpoint, along with the bearer
+// token of its last handshake, which is reused until Spice rejects it.
+type flightEndpoint struct {
+	client flight.Client
+
+	mu    sync.Mutex
+	token string
+}
+
+func newFlightClient(apiKey string, flightAddress string, firecacheAddress string, dialOpts ...grpc.DialOption) (*flightClient, error) {
+	flightConn, err := flight.NewClientWithMiddleware(flightAddress, nil, nil, dialOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("flight client: %w", err)
+	}
+
+	firecacheConn, err := flight.NewClientWithMiddleware(firecacheAddress, nil, nil, dialOpts...)
+	if err != nil {
+		flightConn.Close()
+		return nil, fmt.Errorf("firecache client: %w", err)
+	}
+
+	return &flightClient{
+		apiKey:    apiKey,
+		flight:    newFlightEndpoint(flightConn),
+		firecache: newFlightEndpoint(firecacheConn),
+	}, nil
+}
+
+func newFlightEndpoint(client flight.Client) *flightEndpoint {
+	return &flightEndpoint{client: client}
+}
+
+func (c *flightClient) Query(ctx context.Context, sql string) (array.RecordReader, error) {
+	return c.query(ctx, c.flight, sql)
+}
+
+func (c *flightClient) FireQuery(ctx context.Context, sql string) (array.RecordReader, error) {
+	return c.query(ctx, c.firecache, sql)
+}
+
+// handshake authenticates with the API key on the Flight endpoint, caching
+// the bearer token for the next queries.
+func (c *flightClient) handshake(ctx context.Context) error {
+	_, err := c.authenticate(ctx, c.flight)
+	return err
+}
+
+// authenticate performs a handshake with the API key on endpoint and caches
+// the bearer token it returns.
+func (c *flightClient) authenticate(ctx context.Context, endpoint *flightEndpoint) (string, error) {
+	appID := strings.Split(c.apiKey, "|")[0]
+
+	authCtx, err := endpoint.client.AuthenticateBasicToken(ctx, appID, c.apiKey)
+	if err != nil {
+		return "", err
+	}
+
+	md, _ := metadata.FromOutgoingContext(authCtx)
+	tokens := md.Get(authorizationHeader)
+	if len(tokens) == 0 {
+		return "", errors.New("flight handshake returned no bearer token")
+	}
+
+	token := tokens[len(tokens)-1]
+	endpoint.setToken(token)
+	return token, nil
+}
+
+// query streams the result of sql from endpoint with the cached bearer
+// token, authenticating first when there is none. A cached token rejected by
+// Spice is renewed once.
+func (c *flightClient) query(ctx context.Context, endpoint *flightEndpoint, sql string) (array.RecordReader, error) {
+	token := endpoint.cachedToken()
+	cached := token != ""
+
+	if !cached {
+		var err error
+		if token, err = c.authenticate(ctx, endpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	reader, err := doGet(ctx, endpoint.client, token, sql)
+	if !cached || !isUnauthenticated(err) {
+		return reader, err
+	}
+
+	endpoint.resetToken(token)
+
+	if token, err = c.authenticate(ctx, endpoint); err != nil {
+		return nil, err
+	}
+	return doGet(ctx, endpoint.client, token, sql)
+}
+
+// doGet streams the result of sql from the first endpoint of its flight,
+// authorized by the bearer token.
+func doGet(ctx context.Context, client flight.Client, token string, sql string) (array.RecordReader, error) {
+	ctx = metadata.AppendToOutgoingContext(ctx, authorizationHeader, token)
+
+	info, err := client.GetFlightInfo(ctx, &flight.FlightDescriptor{
+		Type: flight.DescriptorCMD,
+		Cmd:  []byte(sql),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(info.Endpoint) == 0 {
+		return nil, errors.New("flight info has no endpoint")
+	}
+
+	stream, err := client.DoGet(ctx, info.Endpoint[0].Ticket)
+	if err != nil {
+		return nil, err
+	}
+
+	return flight.NewRecordReader(stream)
+}
+
+func (c *flightClient) Close() error {
+	return errors.Join(c.flight.client.Close(), c.firecache.client.Close())
+}
+
+func (e *flightEndpoint) cachedToken() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.token
+}
+
+func (e *flightEndpoint) setToken(token string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.token = token
+}
+
+// resetToken clears the cached token when it is still token, so a token
+// renewed by a concurrent query is kept.
+func (e *flightEndpoint) resetToken(token string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.token == token {
+		e.token = ""
+	}
+}
@@ -0,0 +1,164 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/apache/arrow/go/v14/arrow/flight"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/proxy"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestNewSpiceClient(t *testing.T) {
+	config := datasourceSettings{FlightAddress: "spice.internal:50051", FirecacheAddress: "spice.internal:50052"}
+
+	t.Run("custom tls", func(t *testing.T) {
+		opts := httpclient.Options{TLS: &httpclient.TLSOptions{InsecureSkipVerify: true}}
+
+		client, err := newSpiceClient(config, "000000|invalid", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		if _, ok := client.(*flightClient); !ok {
+			t.Fatalf("custom tls must use the plugin flight client, %T", client)
+		}
+	})
+
+	t.Run("secure socks proxy", func(t *testing.T) {
+		opts := httpclient.Options{ProxyOptions: &proxy.Options{
+			Enabled:   true,
+			ClientCfg: &proxy.ClientCfg{ProxyAddress: "localhost:9999", AllowInsecure: true},
+		}}
+
+		dialOpts, err := flightDialOptions(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(dialOpts) != 2 {
+			t.Fatalf("flight must be dialed through the proxy, %v", dialOpts)
+		}
+
+		client, err := newSpiceClient(config, "000000|invalid", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		if _, ok := client.(*flightClient); !ok {
+			t.Fatalf("proxy must use the plugin flight client, %T", client)
+		}
+	})
+
+	t.Run("invalid ca", func(t *testing.T) {
+		opts := httpclient.Options{TLS: &httpclient.TLSOptions{CACertificate: "not a certificate"}}
+
+		if _, err := newSpiceClient(config, "000000|invalid", opts); err == nil {
+			t.Fatal("invalid CA certificate must fail")
+		}
+	})
+
+	t.Run("server name", func(t *testing.T) {
+		s, err := loadSettings(backend.DataSourceInstanceSettings{JSONData: []byte(`{"serverName":"spice.internal"}`)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		opts := httpclient.Options{}
+		s.applyTLSOptions(&opts)
+
+		if opts.TLS == nil || opts.TLS.ServerName != "spice.internal" {
+			t.Fatalf("server name must be set, %+v", opts.TLS)
+		}
+
+		if s.FlightAddress != defaultFlightAddress || s.FirecacheAddress != defaultFirecacheAddress {
+			t.Fatalf("wrong default addresses, %v %v", s.FlightAddress, s.FirecacheAddress)
+		}
+
+		if s.DatasetsURL != defaultDatasetsURL {
+			t.Fatalf("wrong default datasets url, %v", s.DatasetsURL)
+		}
+	})
+
+	t.Run("self-hosted datasets url", func(t *testing.T) {
+		s, err := loadSettings(backend.DataSourceInstanceSettings{JSONData: []byte(`{"flightAddress":"spice.internal:50051"}`)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if s.DatasetsURL != "" {
+			t.Fatalf("self-hosted runtimes must not default to the Spice Cloud datasets api, %v", s.DatasetsURL)
+		}
+	})
+}
+
+// testFlightClient is a flight.Client whose handshakes return numbered bearer
+// tokens and whose queries fail with the error returned by info.
+type testFlightClient struct {
+	flight.Client
+	handshakes int
+	info       func(token string) error
+}
+
+func (c *testFlightClient) AuthenticateBasicToken(ctx context.Context, username string, password string, opts ...grpc.CallOption) (context.Context, error) {
+	c.handshakes++
+	return metadata.AppendToOutgoingContext(ctx, authorizationHeader, fmt.Sprintf("Bearer %d", c.handshakes)), nil
+}
+
+func (c *testFlightClient) GetFlightInfo(ctx context.Context, desc *flight.FlightDescriptor, opts ...grpc.CallOption) (*flight.FlightInfo, error) {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	return nil, c.info(md.Get(authorizationHeader)[0])
+}
+
+func (c *testFlightClient) Close() error {
+	return nil
+}
+
+func TestFlightClientToken(t *testing.T) {
+	badQuery := status.Error(codes.InvalidArgument, "bad query")
+
+	t.Run("reuses token", func(t *testing.T) {
+		conn := &testFlightClient{info: func(token string) error { return badQuery }}
+		c := &flightClient{apiKey: "1|key", flight: newFlightEndpoint(conn), firecache: newFlightEndpoint(conn)}
+
+		for i := 0; i < 3; i++ {
+			if _, err := c.Query(context.Background(), "SELECT 1"); status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("wrong error, %v", err)
+			}
+		}
+
+		if conn.handshakes != 1 {
+			t.Fatalf("token must be reused, %v handshakes", conn.handshakes)
+		}
+	})
+
+	t.Run("renews rejected token", func(t *testing.T) {
+		conn := &testFlightClient{info: func(token string) error {
+			if token == "Bearer 1" {
+				return status.Error(codes.Unauthenticated, "token expired")
+			}
+			return badQuery
+		}}
+		c := &flightClient{apiKey: "1|key", flight: newFlightEndpoint(conn), firecache: newFlightEndpoint(conn)}
+
+		if err := c.handshake(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := c.Query(context.Background(), "SELECT 1"); status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("wrong error, %v", err)
+		}
+
+		if conn.handshakes != 2 || c.flight.cachedToken() != "Bearer 2" {
+			t.Fatalf("rejected token must be renewed, %v %v", conn.handshakes, c.flight.cachedToken())
+		}
+	})
+}
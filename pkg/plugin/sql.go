@@ -0,0 +1,117 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/apache/arrow/go/v14/arrow"
+)
+
+// adhocOperators maps Grafana ad-hoc filter operators to SQL operators.
+var adhocOperators = map[string]string{
+	"=":  "=",
+	"!=": "<>",
+	"<":  "<",
+	">":  ">",
+	"<=": "<=",
+	">=": ">=",
+	"=~": "~",
+	"!~": "!~",
+}
+
+// quoteIdentifier quotes a possibly qualified SQL identifier such as
+// eth.recent_blocks, quoting each part separately.
+func quoteIdentifier(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = quoteColumn(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+// quoteColumn quotes a column name as a single SQL identifier.
+func quoteColumn(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// quoteLiteral quotes value as a SQL string literal.
+func quoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// isPatternOperator reports whether the ad-hoc filter operator matches a
+// regular expression, whose value is always a string.
+func isPatternOperator(operator string) bool {
+	return operator == "=~" || operator == "!~"
+}
+
+// numericSQLType returns the SQL type of a numeric Arrow type, or false when
+// t is not numeric.
+func numericSQLType(t arrow.DataType) (string, bool) {
+	switch t := t.(type) {
+	case *arrow.Int8Type:
+		return "TINYINT", true
+	case *arrow.Int16Type:
+		return "SMALLINT", true
+	case *arrow.Int32Type:
+		return "INT", true
+	case *arrow.Int64Type:
+		return "BIGINT", true
+	case *arrow.Uint8Type:
+		return "TINYINT UNSIGNED", true
+	case *arrow.Uint16Type:
+		return "SMALLINT UNSIGNED", true
+	case *arrow.Uint32Type:
+		return "INT UNSIGNED", true
+	case *arrow.Uint64Type:
+		return "BIGINT UNSIGNED", true
+	case *arrow.Float32Type:
+		return "REAL", true
+	case *arrow.Float64Type:
+		return "DOUBLE", true
+	case *arrow.Decimal128Type:
+		return fmt.Sprintf("DECIMAL(%d, %d)", t.Precision, t.Scale), true
+	}
+	return "", false
+}
+
+// applyAdhocFilters wraps sql in an outer query restricting its rows to
+// those matching every filter. Filter keys are quoted as column names and
+// values as string literals, so values such as zip codes keep their leading
+// zeros. Values compared with a numeric column, whose type is looked up in
+// columnTypes, are cast to the column type, as Spice would otherwise compare
+// them as text. sql is wrapped on its own lines so a trailing line comment
+// does not comment out the outer query.
+func applyAdhocFilters(sql string, filters []adhocFilter, columnTypes map[string]arrow.DataType) (string, error) {
+	if len(filters) == 0 {
+		return sql, nil
+	}
+
+	conditions := make([]string, 0, len(filters))
+
+	for _, filter := range filters {
+		if filter.Key == "" {
+			return "", fmt.Errorf("ad-hoc filter without key")
+		}
+
+		operator, ok := adhocOperators[filter.Operator]
+		if !ok {
+			return "", fmt.Errorf("unsupported ad-hoc filter operator %q", filter.Operator)
+		}
+
+		value := quoteLiteral(filter.Value)
+		if sqlType, ok := numericSQLType(columnTypes[filter.Key]); ok && !isPatternOperator(filter.Operator) {
+			value = fmt.Sprintf("CAST(%s AS %s)", value, sqlType)
+		}
+
+		conditions = append(conditions, fmt.Sprintf("%s %s %s", quoteColumn(filter.Key), operator, value))
+	}
+
+	return fmt.Sprintf("SELECT * FROM (\n%s\n) AS adhoc_filtered WHERE %s", trimSQL(sql), strings.Join(conditions, " AND ")), nil
+}
+
+// trimSQL removes surrounding whitespace and trailing semicolons from sql so
+// it can be used as a subquery.
+func trimSQL(sql string) string {
+	return strings.TrimRight(strings.TrimSpace(sql), "; \t\n")
+}
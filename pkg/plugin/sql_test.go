@@ -0,0 +1,90 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/apache/arrow/go/v14/arrow"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestQuoteIdentifier(t *testing.T) {
+	if q := quoteIdentifier("eth.recent_blocks"); q != `"eth"."recent_blocks"` {
+		t.Fatalf("wrong identifier, %v", q)
+	}
+
+	if q := quoteIdentifier(`bad"name`); q != `"bad""name"` {
+		t.Fatalf("wrong identifier, %v", q)
+	}
+}
+
+func TestApplyAdhocFilters(t *testing.T) {
+	t.Run("no filters", func(t *testing.T) {
+		sql, err := applyAdhocFilters("SELECT 1", nil, nil)
+		if err != nil || sql != "SELECT 1" {
+			t.Fatalf("wrong sql, %v %v", sql, err)
+		}
+	})
+
+	t.Run("quoted filters", func(t *testing.T) {
+		sql, err := applyAdhocFilters("SELECT * FROM eth.recent_blocks;", []adhocFilter{
+			{Key: "miner", Operator: "=", Value: "x' OR '1'='1"},
+			{Key: "number", Operator: ">", Value: "100"},
+			{Key: "extra_data", Operator: "=~", Value: "^0x"},
+			{Key: "number", Operator: "!~", Value: "^1"},
+		}, map[string]arrow.DataType{"number": arrow.PrimitiveTypes.Int64, "miner": arrow.BinaryTypes.String})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := "SELECT * FROM (\nSELECT * FROM eth.recent_blocks\n) AS adhoc_filtered WHERE " +
+			`"miner" = 'x'' OR ''1''=''1' AND "number" > CAST('100' AS BIGINT) AND "extra_data" ~ '^0x' AND "number" !~ '^1'`
+		if sql != expected {
+			t.Fatalf("wrong sql, %v", sql)
+		}
+	})
+
+	t.Run("trailing comment and numeric string", func(t *testing.T) {
+		sql, err := applyAdhocFilters("SELECT * FROM addresses -- latest", []adhocFilter{
+			{Key: "zip", Operator: "=", Value: "02134"},
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := "SELECT * FROM (\nSELECT * FROM addresses -- latest\n) AS adhoc_filtered WHERE \"zip\" = '02134'"
+		if sql != expected {
+			t.Fatalf("wrong sql, %v", sql)
+		}
+	})
+
+	t.Run("unsupported operator", func(t *testing.T) {
+		if _, err := applyAdhocFilters("SELECT 1", []adhocFilter{{Key: "a", Operator: "; DROP", Value: "1"}}, nil); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
+
+func TestAdhocColumnTypes(t *testing.T) {
+	client := &testSpiceClient{}
+	ds := &Datasource{spice: client}
+
+	q := spiceQuery{QueryText: "SELECT number FROM eth.blocks", AdhocFilters: []adhocFilter{{Key: "number", Operator: "=~", Value: "^1"}}}
+	if types := ds.adhocColumnTypes(context.Background(), backend.PluginContext{}, q); types != nil || client.queries != 0 {
+		t.Fatal("pattern filters must not look up column types")
+	}
+
+	client.reader = testRecordReader(t)
+	q.AdhocFilters = append(q.AdhocFilters, adhocFilter{Key: "number", Operator: ">", Value: "100"})
+
+	sql, err := ds.expandQuery(context.Background(), backend.PluginContext{}, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "SELECT * FROM (\nSELECT number FROM eth.blocks\n) AS adhoc_filtered WHERE " +
+		`"number" ~ '^1' AND "number" > CAST('100' AS BIGINT)`
+	if sql != expected {
+		t.Fatalf("wrong sql, %v", sql)
+	}
+}
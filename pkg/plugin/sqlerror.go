@@ -0,0 +1,93 @@
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// sqlError is a DataFusion planner or parser error returned by Spice, split
+// into its message, kind and the position of the offending token.
+type sqlError struct {
+	Message string `json:"message"`
+	Kind    string `json:"kind"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+}
+
+var (
+	rpcErrorPrefix = regexp.MustCompile(`^(?s).*rpc error: code = \w+ desc = `)
+	sqlErrorPos    = regexp.MustCompile(`(?i)\s*at line:? (\d+),? column:? (\d+)`)
+
+	// DataFusion error prefixes and the kind they are reported as
+	sqlErrorKinds = []struct {
+		prefix string
+		kind   string
+	}{
+		{"sql parser error: ", "parser"},
+		{"SQL error: ParserError(", "parser"},
+		{"SQL error: ", "parser"},
+		{"Error during planning: ", "planning"},
+		{"Schema error: ", "schema"},
+		{"This feature is not implemented: ", "not_implemented"},
+		{"Execution error: ", "execution"},
+		{"Arrow error: ", "arrow"},
+		{"Internal error: ", "internal"},
+	}
+)
+
+// parseSQLError extracts a sqlError from err, returning nil when err is not a
+// recognized DataFusion error.
+func parseSQLError(err error) *sqlError {
+	desc := rpcErrorPrefix.ReplaceAllString(err.Error(), "")
+
+	for _, k := range sqlErrorKinds {
+		if !strings.HasPrefix(desc, k.prefix) {
+			continue
+		}
+
+		message := strings.TrimPrefix(desc, k.prefix)
+		if strings.HasSuffix(k.prefix, "(") {
+			message = strings.Trim(strings.TrimSuffix(message, ")"), `"`)
+		}
+
+		e := &sqlError{Kind: k.kind}
+
+		if m := sqlErrorPos.FindStringSubmatchIndex(message); m != nil {
+			e.Line, _ = strconv.Atoi(message[m[2]:m[3]])
+			e.Column, _ = strconv.Atoi(message[m[4]:m[5]])
+			message = message[:m[0]] + message[m[1]:]
+		}
+
+		e.Message = strings.TrimSpace(message)
+		return e
+	}
+
+	return nil
+}
+
+func (e *sqlError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s error: %s (line %d, column %d)", e.Kind, e.Message, e.Line, e.Column)
+	}
+	return fmt.Sprintf("%s error: %s", e.Kind, e.Message)
+}
+
+// frame returns an empty frame carrying the error as a notice, with the
+// structured error in the custom metadata for the query editor.
+func (e *sqlError) frame() *data.Frame {
+	frame := data.NewFrame("error")
+	frame.SetMeta(&data.FrameMeta{
+		Custom: map[string]interface{}{
+			"error": e,
+		},
+	})
+	frame.AppendNotices(data.Notice{
+		Severity: data.NoticeSeverityError,
+		Text:     e.Error(),
+	})
+	return frame
+}
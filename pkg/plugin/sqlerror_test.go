@@ -0,0 +1,65 @@
+package plugin
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseSQLError(t *testing.T) {
+	t.Run("parser error with position", func(t *testing.T) {
+		err := errors.New("rpc error: code = InvalidArgument desc = sql parser error: Expected end of statement, found: FROM at Line: 1, Column 8")
+
+		e := parseSQLError(err)
+		if e == nil {
+			t.Fatal("expected sql error")
+		}
+
+		if e.Kind != "parser" {
+			t.Fatalf("wrong kind, %v", e.Kind)
+		}
+
+		if e.Message != "Expected end of statement, found: FROM" {
+			t.Fatalf("wrong message, %v", e.Message)
+		}
+
+		if e.Line != 1 || e.Column != 8 {
+			t.Fatalf("wrong position, %v %v", e.Line, e.Column)
+		}
+	})
+
+	t.Run("legacy parser error", func(t *testing.T) {
+		err := errors.New(`rpc error: code = Unknown desc = SQL error: ParserError("Expected an expression:, found: EOF")`)
+
+		e := parseSQLError(err)
+		if e == nil {
+			t.Fatal("expected sql error")
+		}
+
+		if e.Kind != "parser" || e.Message != "Expected an expression:, found: EOF" {
+			t.Fatalf("wrong error, %v %v", e.Kind, e.Message)
+		}
+	})
+
+	t.Run("planning error", func(t *testing.T) {
+		err := errors.New("rpc error: code = Internal desc = Error during planning: table 'eth.missing' not found")
+
+		e := parseSQLError(err)
+		if e == nil {
+			t.Fatal("expected sql error")
+		}
+
+		if e.Kind != "planning" || e.Message != "table 'eth.missing' not found" {
+			t.Fatalf("wrong error, %v %v", e.Kind, e.Message)
+		}
+
+		if e.Line != 0 {
+			t.Fatalf("unexpected position, %v", e.Line)
+		}
+	})
+
+	t.Run("unrecognized error", func(t *testing.T) {
+		if e := parseSQLError(errors.New("rpc error: code = Unavailable desc = connection refused")); e != nil {
+			t.Fatalf("unexpected sql error, %v", e)
+		}
+	})
+}
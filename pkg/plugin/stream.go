@@ -0,0 +1,104 @@
+package plugin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	streamPathPrefix = "stream/"
+
+	// streamSubscribeTimeout is how long a partial results channel waits for
+	// its panel to subscribe before it is discarded.
+	streamSubscribeTimeout = time.Minute
+)
+
+// streamChannel registers q as a partial results query and returns the
+// Grafana Live channel its record batches are sent to. Every execution gets
+// its own channel, so a subscriber never joins a scan already under way and
+// mistakes its remaining batches for the full result.
+func (d *Datasource) streamChannel(pCtx backend.PluginContext, q spiceQuery) string {
+	identity := d.channelIdentity(pCtx)
+	path := channelPath(streamPathPrefix, q, identity) + "/" + executionID()
+	d.live.registerOnce(path, q, identity, streamSubscribeTimeout)
+
+	return d.channel(path)
+}
+
+// executionID returns a random identifier of a query execution.
+func executionID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// runPartialStream runs q and sends every record batch to the subscribers of
+// the channel path as soon as it is read, so large scans render
+// progressively. The query is cancelled when the last subscriber leaves, and
+// audited once it finishes.
+//
+// Grafana runs a stream again when it fails, but the channel of an execution
+// is gone by then, so errors are sent to the subscribers as a notice instead.
+func (d *Datasource) runPartialStream(ctx context.Context, pCtx backend.PluginContext, path string, q spiceQuery, sender *backend.StreamSender) error {
+	start := time.Now()
+
+	sql, rows, err := d.streamBatches(ctx, pCtx, q, sender)
+	if sql != "" {
+		d.auditQuery(ctx, pCtx, q.QuerySource, sql, start, rows, err)
+	}
+
+	if err == nil || ctx.Err() != nil {
+		return nil
+	}
+
+	d.logger(ctx).Error("Streamed query failed", "path", path, "error", d.redact(err.Error()))
+
+	frame := data.NewFrame("error")
+	frame.AppendNotices(data.Notice{Severity: data.NoticeSeverityError, Text: d.redact(err.Error())})
+
+	return sender.SendFrame(frame, data.IncludeAll)
+}
+
+// streamBatches runs q and sends each record batch as a frame, returning the
+// executed SQL and the number of rows sent.
+func (d *Datasource) streamBatches(ctx context.Context, pCtx backend.PluginContext, q spiceQuery, sender *backend.StreamSender) (string, int64, error) {
+	sql, err := d.expandQuery(ctx, pCtx, q)
+	if err != nil {
+		return "", 0, err
+	}
+
+	reader, err := d.SpiceQuery(ctx, sql, q.QuerySource)
+	if err != nil {
+		return sql, 0, err
+	}
+	defer reader.Release()
+
+	schema := reader.Schema()
+	include := data.IncludeAll
+	var rows int64
+
+	for reader.Next() {
+		record := reader.Record()
+
+		frame, err := shapeTimeFrame(recordToFrame(schema, record), q.TimeColumn)
+		if err != nil {
+			return sql, rows, err
+		}
+
+		if err := sender.SendFrame(frame, include); err != nil {
+			return sql, rows, err
+		}
+		rows += record.NumRows()
+		include = data.IncludeDataOnly
+	}
+
+	return sql, rows, reader.Err()
+}
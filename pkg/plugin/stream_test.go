@@ -0,0 +1,78 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// testPacketSender collects the packets sent to a stream.
+type testPacketSender struct {
+	packets []*backend.StreamPacket
+}
+
+func (s *testPacketSender) Send(packet *backend.StreamPacket) error {
+	s.packets = append(s.packets, packet)
+	return nil
+}
+
+func TestStreamQuery(t *testing.T) {
+	ds := &Datasource{settings: backend.DataSourceInstanceSettings{UID: "spice"}}
+
+	run := func() string {
+		res := ds.query(context.Background(), backend.PluginContext{}, backend.DataQuery{
+			RefID: "A",
+			JSON:  json.RawMessage(`{"queryText": "SELECT * FROM eth.blocks", "querySource": "default", "stream": true}`),
+		})
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+
+		if len(res.Frames) != 1 || res.Frames[0].Meta == nil {
+			t.Fatal("stream query must return a frame with metadata")
+		}
+		return res.Frames[0].Meta.Channel
+	}
+
+	channel := run()
+
+	q := spiceQuery{QueryText: "SELECT * FROM eth.blocks", QuerySource: "default"}
+	if !strings.HasPrefix(channel, "ds/spice/stream/"+queryHash(q)+"/") {
+		t.Fatalf("wrong channel, %v", channel)
+	}
+
+	if run() == channel {
+		t.Fatal("every execution must get its own channel")
+	}
+
+	path := strings.TrimPrefix(channel, "ds/spice/")
+	sub, _ := ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{Path: path})
+	if sub.Status != backend.SubscribeStreamStatusOK {
+		t.Fatal("stream channel must be subscribable")
+	}
+
+	body, _ := json.Marshal(q)
+	sub, _ = ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{Path: "stream/" + queryHash(q) + "/unknown", Data: body})
+	if sub.Status != backend.SubscribeStreamStatusNotFound {
+		t.Fatal("stream channels must not be registered from the subscription data")
+	}
+}
+
+func TestPartialStreamError(t *testing.T) {
+	ds := &Datasource{spice: &testSpiceClient{err: status.Error(codes.Unavailable, "unavailable")}}
+	sender := &testPacketSender{}
+
+	err := ds.runPartialStream(context.Background(), backend.PluginContext{}, "stream/a/1", spiceQuery{QueryText: "SELECT 1"}, backend.NewStreamSender(sender))
+	if err != nil {
+		t.Fatalf("failed stream must not be run again, %v", err)
+	}
+
+	if len(sender.packets) != 1 || !strings.Contains(string(sender.packets[0].Data), "unavailable") {
+		t.Fatalf("error must be sent as a notice, %v", sender.packets)
+	}
+}
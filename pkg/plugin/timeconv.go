@@ -0,0 +1,176 @@
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// epochToTime converts a Unix timestamp to a time, inferring its unit
+// (seconds, milliseconds, microseconds or nanoseconds) from its magnitude.
+func epochToTime(v int64) time.Time {
+	abs := v
+	if abs < 0 {
+		abs = -abs
+	}
+
+	switch {
+	case abs < 1e11:
+		return time.Unix(v, 0).UTC()
+	case abs < 1e14:
+		return time.UnixMilli(v).UTC()
+	case abs < 1e17:
+		return time.UnixMicro(v).UTC()
+	default:
+		return time.Unix(0, v).UTC()
+	}
+}
+
+// timeValues converts a time field or an integer field holding Unix
+// timestamps to times.
+func timeValues(field *data.Field) ([]time.Time, error) {
+	times := make([]time.Time, field.Len())
+
+	for i := range times {
+		v, ok := field.ConcreteAt(i)
+		if !ok {
+			continue
+		}
+
+		switch v := v.(type) {
+		case time.Time:
+			times[i] = v
+		case int64:
+			times[i] = epochToTime(v)
+		case int32:
+			times[i] = epochToTime(int64(v))
+		case uint64:
+			times[i] = epochToTime(int64(v))
+		case uint32:
+			times[i] = epochToTime(int64(v))
+		default:
+			return nil, fmt.Errorf("column %s of type %s cannot be used as a time", field.Name, field.Type())
+		}
+	}
+
+	return times, nil
+}
+
+// shapeTimeFrame prepares frame for time series panels. The time field is
+// found by timeFieldIndex. Integer timestamps are converted to times, and the
+// time field is moved first with the rows sorted by it in ascending order.
+// Frames without a time field are returned unchanged.
+func shapeTimeFrame(frame *data.Frame, timeColumn string) (*data.Frame, error) {
+	index := timeFieldIndex(frame, timeColumn)
+	if index < 0 {
+		if timeColumn != "" {
+			return nil, fmt.Errorf("time column %s not found", timeColumn)
+		}
+		return frame, nil
+	}
+
+	timeField := frame.Fields[index]
+	if !timeField.Type().Time() {
+		times, err := timeValues(timeField)
+		if err != nil {
+			return nil, err
+		}
+
+		converted := data.NewField(timeField.Name, timeField.Labels, times)
+		converted.Config = timeField.Config
+		timeField = converted
+	}
+
+	fields := make([]*data.Field, 0, len(frame.Fields))
+	fields = append(fields, timeField)
+	fields = append(fields, frame.Fields[:index]...)
+	fields = append(fields, frame.Fields[index+1:]...)
+	frame.Fields = fields
+
+	return sortFrameByTime(frame), nil
+}
+
+// timeFieldIndex returns the index of the time field of frame, or -1 when it
+// has none: the field named timeColumn when set, otherwise the first time
+// field or, failing that, the first integer field named like a Unix
+// timestamp.
+func timeFieldIndex(frame *data.Frame, timeColumn string) int {
+	if timeColumn != "" {
+		for i, field := range frame.Fields {
+			if strings.EqualFold(field.Name, timeColumn) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	for i, field := range frame.Fields {
+		if field.Type().Time() {
+			return i
+		}
+	}
+
+	for i, field := range frame.Fields {
+		if isIntegerField(field) && isTimeColumnName(field.Name) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func isIntegerField(field *data.Field) bool {
+	switch field.Type() {
+	case data.FieldTypeInt32, data.FieldTypeInt64, data.FieldTypeUint32, data.FieldTypeUint64:
+		return true
+	}
+	return false
+}
+
+// sortFrameByTime sorts the rows of frame by its first field, which must be
+// a time field, in ascending order.
+func sortFrameByTime(frame *data.Frame) *data.Frame {
+	timeField := frame.Fields[0]
+	rows := timeField.Len()
+
+	timeAt := func(i int) time.Time {
+		v, _ := timeField.ConcreteAt(i)
+		t, _ := v.(time.Time)
+		return t
+	}
+
+	order := make([]int, rows)
+	sorted := true
+	for i := range order {
+		order[i] = i
+		if i > 0 && timeAt(i).Before(timeAt(i-1)) {
+			sorted = false
+		}
+	}
+
+	if sorted {
+		return frame
+	}
+
+	sort.SliceStable(order, func(a, b int) bool {
+		return timeAt(order[a]).Before(timeAt(order[b]))
+	})
+
+	for i, field := range frame.Fields {
+		reordered := data.NewFieldFromFieldType(field.Type(), rows)
+		reordered.Name = field.Name
+		reordered.Labels = field.Labels
+		reordered.Config = field.Config
+
+		for row, from := range order {
+			reordered.Set(row, field.At(from))
+		}
+
+		frame.Fields[i] = reordered
+	}
+
+	return frame
+}
@@ -0,0 +1,85 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestShapeTimeFrame(t *testing.T) {
+	t.Run("epoch column", func(t *testing.T) {
+		frame := data.NewFrame("response",
+			data.NewField("number", nil, []int64{2, 1, 3}),
+			data.NewField("block_timestamp", nil, []int64{1700000060, 1700000000, 1700000120}))
+
+		shaped, err := shapeTimeFrame(frame, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if shaped.Fields[0].Name != "block_timestamp" || !shaped.Fields[0].Type().Time() {
+			t.Fatalf("time field must be first, %v %v", shaped.Fields[0].Name, shaped.Fields[0].Type())
+		}
+
+		if shaped.Fields[0].At(0) != time.Unix(1700000000, 0).UTC() {
+			t.Fatalf("wrong first time, %v", shaped.Fields[0].At(0))
+		}
+
+		for i, want := range []int64{1, 2, 3} {
+			if shaped.Fields[1].At(i) != want {
+				t.Fatalf("rows must be sorted by time, %v", shaped.Fields[1].At(i))
+			}
+		}
+	})
+
+	t.Run("time column override", func(t *testing.T) {
+		now := time.Now().UTC()
+		frame := data.NewFrame("response",
+			data.NewField("time", nil, []time.Time{now, now.Add(time.Minute)}),
+			data.NewField("updated", nil, []time.Time{now.Add(time.Hour), now}))
+
+		shaped, err := shapeTimeFrame(frame, "updated")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if shaped.Fields[0].Name != "updated" || shaped.Fields[0].At(0) != now {
+			t.Fatalf("wrong time field, %v %v", shaped.Fields[0].Name, shaped.Fields[0].At(0))
+		}
+
+		if _, err := shapeTimeFrame(frame, "missing"); err == nil {
+			t.Fatal("missing time column must fail")
+		}
+	})
+
+	t.Run("time field before integer heuristic", func(t *testing.T) {
+		now := time.Now().UTC()
+		frame := data.NewFrame("response",
+			data.NewField("exec_time", nil, []int64{30, 10}),
+			data.NewField("block_timestamp", nil, []int64{1700000060, 1700000000}),
+			data.NewField("created", nil, []time.Time{now.Add(time.Minute), now}))
+
+		shaped, err := shapeTimeFrame(frame, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if shaped.Fields[0].Name != "created" || shaped.Fields[0].At(0) != now {
+			t.Fatalf("wrong time field, %v %v", shaped.Fields[0].Name, shaped.Fields[0].At(0))
+		}
+	})
+
+	t.Run("no time column", func(t *testing.T) {
+		frame := data.NewFrame("response", data.NewField("number", nil, []int64{2, 1}))
+
+		shaped, err := shapeTimeFrame(frame, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if shaped.Fields[0].At(0) != int64(2) {
+			t.Fatal("frame without time column must be unchanged")
+		}
+	})
+}
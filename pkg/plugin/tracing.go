@@ -0,0 +1,56 @@
+package plugin
+
+import (
+	"context"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+// Span attribute keys
+const (
+	attributeRefID       = attribute.Key("spice.ref_id")
+	attributeQuerySource = attribute.Key("spice.query_source")
+	attributeRows        = attribute.Key("spice.rows")
+	attributeBytes       = attribute.Key("spice.bytes")
+	attributeBatches     = attribute.Key("spice.batches")
+	attributeFirstBatch  = attribute.Key("spice.time_to_first_batch_ms")
+)
+
+// startSpan starts a span of the tracer the plugin SDK configures from the
+// Grafana tracing settings. Spans are no-ops when tracing is disabled.
+func startSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracing.DefaultTracer().Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// endSpan records err on span, if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// injectTraceContext adds the trace context of ctx to the outgoing gRPC
+// metadata, so the Flight calls to Spice join the Grafana trace.
+func injectTraceContext(ctx context.Context) context.Context {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+
+	pairs := make([]string, 0, 2*len(carrier))
+	for key, value := range carrier {
+		pairs = append(pairs, key, value)
+	}
+
+	if len(pairs) == 0 {
+		return ctx
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, pairs...)
+}
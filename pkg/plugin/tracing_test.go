@@ -0,0 +1,39 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestInjectTraceContext(t *testing.T) {
+	propagator := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTextMapPropagator(propagator) })
+
+	t.Run("span context", func(t *testing.T) {
+		spanContext := trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    trace.TraceID{1},
+			SpanID:     trace.SpanID{2},
+			TraceFlags: trace.FlagsSampled,
+		})
+		ctx := trace.ContextWithSpanContext(context.Background(), spanContext)
+
+		md, _ := metadata.FromOutgoingContext(injectTraceContext(ctx))
+		traceparent := md.Get("traceparent")
+		if len(traceparent) != 1 || traceparent[0] != "00-01000000000000000000000000000000-0200000000000000-01" {
+			t.Fatalf("wrong traceparent, %v", traceparent)
+		}
+	})
+
+	t.Run("no span context", func(t *testing.T) {
+		ctx := injectTraceContext(context.Background())
+		if _, ok := metadata.FromOutgoingContext(ctx); ok {
+			t.Fatal("context without a span must not carry metadata")
+		}
+	})
+}
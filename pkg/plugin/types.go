@@ -1,6 +1,51 @@
 package plugin
 
 type spiceQuery struct {
-	QueryText   string
-	QuerySource string
+	QueryText    string
+	QuerySource  string
+	AdhocFilters []adhocFilter
+
+	// TimeColumn overrides the detection of the time column of the results.
+	TimeColumn string
+
+	// Format selects the shape of the returned frames: a table by default, or
+	// numeric frames with labels for alerting.
+	Format string
+
+	// Live streams new rows over Grafana Live, polling Spice every
+	// LiveInterval (a duration such as "5s").
+	Live         bool
+	LiveInterval string
+
+	// Stream returns the results progressively over Grafana Live, one frame
+	// per record batch, instead of waiting for the whole query.
+	Stream bool
+}
+
+// adhocFilter is a Grafana ad-hoc filter applied to the query results.
+type adhocFilter struct {
+	Key      string
+	Operator string
+	Value    string
+}
+
+// healthDetails is reported in the JSONDetails of a health check result.
+type healthDetails struct {
+	Endpoint   string        `json:"endpoint"`
+	Query      string        `json:"query"`
+	LatencyMs  int64         `json:"latencyMs"`
+	Reached    bool          `json:"reached"`
+	AuthStatus string        `json:"authStatus"`
+	ActiveKey  string        `json:"activeKey"`
+	Rows       int64         `json:"rows"`
+	Datasets   int           `json:"datasets"`
+	Stages     []healthStage `json:"stages"`
+}
+
+// healthStage is the outcome of a single health check stage.
+type healthStage struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	DurationMs int64  `json:"durationMs"`
+	Message    string `json:"message,omitempty"`
 }
@@ -0,0 +1,107 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	variablesCacheTTL     = 30 * time.Second
+	maxVariableQueryBytes = 1 << 20
+)
+
+// variableValue is a template variable option, as expected by Grafana's
+// metricFindQuery.
+type variableValue struct {
+	Text  string `json:"text"`
+	Value string `json:"value"`
+}
+
+// handleVariables runs the SQL query in the request body and returns its
+// first column as variable texts and its second column, when present, as
+// values.
+func (d *Datasource) handleVariables(w http.ResponseWriter, r *http.Request) {
+	q := spiceQuery{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxVariableQueryBytes)).Decode(&q); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("json decode: %w", err))
+		return
+	}
+
+	if q.QueryText == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("empty query"))
+		return
+	}
+
+	key := identityCacheKey(r.Context(), q.QuerySource+"\x00"+q.QueryText)
+	if values, ok := d.variablesCache.get(key); ok {
+		writeJSON(w, http.StatusOK, values)
+		return
+	}
+
+	pCtx := httpadapter.PluginConfigFromContext(r.Context())
+	start := time.Now()
+
+	reader, err := d.SpiceQuery(r.Context(), q.QueryText, q.QuerySource)
+	if err != nil {
+		d.auditQuery(r.Context(), pCtx, q.QuerySource, q.QueryText, start, 0, err)
+		writeError(w, queryErrorStatus(err), err)
+		return
+	}
+	defer reader.Release()
+
+	frame, stats := recordsToFrame(r.Context(), reader)
+	d.auditQuery(r.Context(), pCtx, q.QuerySource, q.QueryText, start, stats.rows, reader.Err())
+	if err := reader.Err(); err != nil {
+		writeError(w, queryErrorStatus(err), err)
+		return
+	}
+
+	values := variableValues(frame)
+
+	d.variablesCache.set(key, values)
+	writeJSON(w, http.StatusOK, values)
+}
+
+// variableValues converts the first one or two fields of frame into variable
+// options.
+func variableValues(frame *data.Frame) []variableValue {
+	values := []variableValue{}
+
+	if len(frame.Fields) == 0 {
+		return values
+	}
+
+	texts := frame.Fields[0]
+	valueField := texts
+	if len(frame.Fields) > 1 {
+		valueField = frame.Fields[1]
+	}
+
+	for i := 0; i < texts.Len(); i++ {
+		values = append(values, variableValue{
+			Text:  fieldValueString(texts, i),
+			Value: fieldValueString(valueField, i),
+		})
+	}
+
+	return values
+}
+
+// fieldValueString formats the value at index i of field as a string.
+func fieldValueString(field *data.Field, i int) string {
+	v, ok := field.ConcreteAt(i)
+	if !ok {
+		return ""
+	}
+
+	if t, ok := v.(time.Time); ok {
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+
+	return fmt.Sprint(v)
+}
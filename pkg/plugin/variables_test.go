@@ -0,0 +1,90 @@
+package plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestVariableValues(t *testing.T) {
+	t.Run("single column", func(t *testing.T) {
+		frame := data.NewFrame("response",
+			data.NewField("chain", nil, []string{"eth", "btc"}))
+
+		values := variableValues(frame)
+
+		if len(values) != 2 {
+			t.Fatal("wrong number of values")
+		}
+
+		if values[1].Text != "btc" || values[1].Value != "btc" {
+			t.Fatalf("wrong value, %v", values[1])
+		}
+	})
+
+	t.Run("text and value columns", func(t *testing.T) {
+		frame := data.NewFrame("response",
+			data.NewField("name", nil, []string{"Ethereum"}),
+			data.NewField("id", nil, []int64{1}))
+
+		values := variableValues(frame)
+
+		if values[0].Text != "Ethereum" || values[0].Value != "1" {
+			t.Fatalf("wrong value, %v", values[0])
+		}
+	})
+
+	t.Run("empty frame", func(t *testing.T) {
+		if values := variableValues(data.NewFrame("response")); len(values) != 0 {
+			t.Fatal("expected no values")
+		}
+	})
+}
+
+func TestVariablesReaderError(t *testing.T) {
+	client := &testSpiceClient{}
+	ds := &Datasource{
+		spice:          client,
+		variablesCache: newTTLCache[[]variableValue]("variables", variablesCacheTTL),
+	}
+
+	handlers := map[string]func() *httptest.ResponseRecorder{
+		"variables": func() *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/variables", strings.NewReader(`{"queryText": "SELECT chain FROM eth.blocks"}`))
+			ds.handleVariables(w, req)
+			return w
+		},
+		"tag values": func() *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			ds.handleTagValues(w, httptest.NewRequest(http.MethodGet, "/tag-values?key=chain", nil), "eth.blocks")
+			return w
+		},
+	}
+
+	for name, handle := range handlers {
+		t.Run(name, func(t *testing.T) {
+			client.queries = 0
+
+			for i := 0; i < 2; i++ {
+				client.reader = &failingReader{
+					RecordReader: testRecordReader(t, []int64{1}),
+					err:          status.Error(codes.Unavailable, "stream reset"),
+				}
+
+				if w := handle(); w.Code != http.StatusBadGateway {
+					t.Fatalf("a failed read must return an error, %v", w.Code)
+				}
+			}
+
+			if client.queries != 2 {
+				t.Fatalf("failed reads must not be cached, %v", client.queries)
+			}
+		})
+	}
+}
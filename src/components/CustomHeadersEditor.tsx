@@ -0,0 +1,100 @@
+import React, { ChangeEvent } from 'react';
+import { Button, HorizontalGroup, InlineField, Input, SecretInput } from '@grafana/ui';
+import { DataSourcePluginOptionsEditorProps } from '@grafana/data';
+import { MyDataSourceOptions } from '../types';
+
+interface Props extends DataSourcePluginOptionsEditorProps<MyDataSourceOptions> {}
+
+const headerNameKey = (index: number) => `httpHeaderName${index}`;
+const headerValueKey = (index: number) => `httpHeaderValue${index}`;
+
+/**
+ * Edits the custom headers sent to Spice on HTTP requests and as Flight gRPC
+ * metadata. Headers are stored the way Grafana stores them for every
+ * datasource: names in jsonData and values in secureJsonData, numbered from 1.
+ */
+export function CustomHeadersEditor(props: Props) {
+  const { onOptionsChange, options } = props;
+  const jsonData = options.jsonData as Record<string, any>;
+  const secureJsonData = (options.secureJsonData || {}) as Record<string, string>;
+  const secureJsonFields = options.secureJsonFields || {};
+
+  const names: string[] = [];
+  for (let i = 1; headerNameKey(i) in jsonData; i++) {
+    names.push(jsonData[headerNameKey(i)]);
+  }
+
+  const onNameChange = (index: number, name: string) => {
+    onOptionsChange({ ...options, jsonData: { ...options.jsonData, [headerNameKey(index)]: name } });
+  };
+
+  const onValueChange = (index: number, value: string) => {
+    onOptionsChange({ ...options, secureJsonData: { ...options.secureJsonData, [headerValueKey(index)]: value } });
+  };
+
+  const onValueReset = (index: number) => {
+    onOptionsChange({
+      ...options,
+      secureJsonFields: { ...options.secureJsonFields, [headerValueKey(index)]: false },
+      secureJsonData: { ...options.secureJsonData, [headerValueKey(index)]: '' },
+    });
+  };
+
+  const onAdd = () => {
+    onNameChange(names.length + 1, '');
+  };
+
+  // Headers are numbered without gaps, so removing one shifts the following
+  // headers down.
+  const onRemove = (index: number) => {
+    const newJsonData: Record<string, any> = { ...options.jsonData };
+    const newSecureJsonData: Record<string, string> = { ...secureJsonData };
+    const newSecureJsonFields: Record<string, boolean> = { ...secureJsonFields };
+
+    for (let i = index; i < names.length; i++) {
+      newJsonData[headerNameKey(i)] = names[i];
+      newSecureJsonData[headerValueKey(i)] = secureJsonData[headerValueKey(i + 1)] ?? '';
+      newSecureJsonFields[headerValueKey(i)] = false;
+    }
+    delete newJsonData[headerNameKey(names.length)];
+    newSecureJsonData[headerValueKey(names.length)] = '';
+    newSecureJsonFields[headerValueKey(names.length)] = false;
+
+    onOptionsChange({
+      ...options,
+      jsonData: newJsonData as MyDataSourceOptions,
+      secureJsonData: newSecureJsonData,
+      secureJsonFields: newSecureJsonFields,
+    });
+  };
+
+  return (
+    <>
+      {names.map((name, i) => (
+        <HorizontalGroup key={i}>
+          <InlineField label="Header" labelWidth={24}>
+            <Input
+              value={name}
+              placeholder="X-Tenant"
+              width={20}
+              onChange={(e: ChangeEvent<HTMLInputElement>) => onNameChange(i + 1, e.target.value)}
+            />
+          </InlineField>
+          <InlineField label="Value">
+            <SecretInput
+              isConfigured={secureJsonFields[headerValueKey(i + 1)] as boolean}
+              value={secureJsonData[headerValueKey(i + 1)] || ''}
+              width={20}
+              onReset={() => onValueReset(i + 1)}
+              onChange={(e: ChangeEvent<HTMLInputElement>) => onValueChange(i + 1, e.target.value)}
+            />
+          </InlineField>
+          <Button variant="secondary" icon="trash-alt" aria-label="Remove header" onClick={() => onRemove(i + 1)} />
+        </HorizontalGroup>
+      ))}
+      <Button variant="secondary" icon="plus" onClick={onAdd}>
+        Add header
+      </Button>
+    </>
+  );
+}
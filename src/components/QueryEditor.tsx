@@ -1,11 +1,16 @@
 import React, { useEffect, useState } from 'react';
-import { CodeEditor, Field, RadioButtonGroup } from '@grafana/ui';
+import { CodeEditor, Field, HorizontalGroup, Input, RadioButtonGroup, Switch } from '@grafana/ui';
 import { QueryEditorProps, SelectableValue } from '@grafana/data';
 import { DataSource } from '../datasource';
-import { MyDataSourceOptions, MyQuery, QuerySource } from '../types';
+import { MyDataSourceOptions, MyQuery, QueryFormat, QuerySource } from '../types';
 
 type Props = QueryEditorProps<DataSource, MyQuery, MyDataSourceOptions>;
 
+const formatOptions: Array<SelectableValue<QueryFormat>> = [
+  { label: 'Table', value: 'table' },
+  { label: 'Numeric', value: 'numeric', description: 'Labelled numeric series for alerting and expressions' },
+];
+
 const sourceOptions: Array<SelectableValue<QuerySource>> = [
   { label: 'Spice.ai', value: 'default' },
   { label: 'Firecache', value: 'firecache', icon: 'fire' },
@@ -22,6 +27,29 @@ export function QueryEditor({ query, onChange, onRunQuery, datasource, app }: Pr
     onChange({ ...query, querySource: value });
   };
 
+  const onFormatChange = (value: QueryFormat) => {
+    onChange({ ...query, format: value });
+    onRunQuery();
+  };
+
+  const onLiveChange = (event: React.FormEvent<HTMLInputElement>) => {
+    onChange({ ...query, live: event.currentTarget.checked });
+    onRunQuery();
+  };
+
+  const onStreamChange = (event: React.FormEvent<HTMLInputElement>) => {
+    onChange({ ...query, stream: event.currentTarget.checked });
+    onRunQuery();
+  };
+
+  const onTimeColumnChange = (event: React.FormEvent<HTMLInputElement>) => {
+    onChange({ ...query, timeColumn: event.currentTarget.value });
+  };
+
+  const onLiveIntervalChange = (event: React.FormEvent<HTMLInputElement>) => {
+    onChange({ ...query, liveInterval: event.currentTarget.value });
+  };
+
   useEffect(() => {
     datasource
       .getResource('datasets')
@@ -39,7 +67,7 @@ export function QueryEditor({ query, onChange, onRunQuery, datasource, app }: Pr
     // eslint-disable-next-line react-hooks/exhaustive-deps
   }, [app, datasource]);
 
-  const { queryText, querySource: queryType } = query;
+  const { queryText, querySource: queryType, format, timeColumn, live, liveInterval, stream } = query;
 
   return (
     <div>
@@ -52,6 +80,40 @@ export function QueryEditor({ query, onChange, onRunQuery, datasource, app }: Pr
         />
       </Field>
 
+      <Field label="Format">
+        <RadioButtonGroup options={formatOptions} value={format ?? 'table'} onChange={onFormatChange} />
+      </Field>
+
+      <Field label="Time column" description="Detected from the results when empty">
+        <Input
+          value={timeColumn || ''}
+          placeholder="auto"
+          width={30}
+          onChange={onTimeColumnChange}
+          onBlur={onRunQuery}
+        />
+      </Field>
+
+      <HorizontalGroup>
+        <Field label="Live" description="Stream new rows over Grafana Live">
+          <Switch value={live ?? false} onChange={onLiveChange} />
+        </Field>
+        <Field label="Progressive" description="Render record batches as they arrive">
+          <Switch value={stream ?? false} onChange={onStreamChange} />
+        </Field>
+        {live && (
+          <Field label="Poll interval">
+            <Input
+              value={liveInterval || ''}
+              placeholder="5s"
+              width={10}
+              onChange={onLiveIntervalChange}
+              onBlur={onRunQuery}
+            />
+          </Field>
+        )}
+      </HorizontalGroup>
+
       <Field label="Query">
         <CodeEditor
           width={600}
@@ -1,14 +1,57 @@
-import { DataSourceInstanceSettings, CoreApp } from '@grafana/data';
-import { DataSourceWithBackend } from '@grafana/runtime';
+import { DataSourceInstanceSettings, CoreApp, MetricFindValue, ScopedVars } from '@grafana/data';
+import { DataSourceWithBackend, getTemplateSrv } from '@grafana/runtime';
 
-import { MyQuery, MyDataSourceOptions, DEFAULT_QUERY } from './types';
+import { MyQuery, MyDataSourceOptions, DEFAULT_QUERY, ANNOTATION_QUERY_TYPE } from './types';
 
 export class DataSource extends DataSourceWithBackend<MyQuery, MyDataSourceOptions> {
+  adhocFiltersDataset?: string;
+
   constructor(instanceSettings: DataSourceInstanceSettings<MyDataSourceOptions>) {
     super(instanceSettings);
+
+    this.adhocFiltersDataset = instanceSettings.jsonData.adhocFiltersDataset;
+
+    this.annotations = {
+      prepareQuery: (anno) => ({ ...anno.target, refId: anno.target?.refId ?? 'Anno', queryType: ANNOTATION_QUERY_TYPE }),
+    };
   }
 
   getDefaultQuery(_: CoreApp): Partial<MyQuery> {
     return DEFAULT_QUERY
   }
+
+  applyTemplateVariables(query: MyQuery, scopedVars: ScopedVars): MyQuery {
+    const templateSrv = getTemplateSrv();
+
+    return {
+      ...query,
+      queryText: templateSrv.replace(query.queryText ?? '', scopedVars),
+      adhocFilters: templateSrv.getAdhocFilters(this.name),
+    };
+  }
+
+  async getTagKeys(): Promise<MetricFindValue[]> {
+    if (!this.adhocFiltersDataset) {
+      return [];
+    }
+
+    return this.getResource(`datasets/${this.adhocFiltersDataset}/tag-keys`);
+  }
+
+  async getTagValues(options: { key: string }): Promise<MetricFindValue[]> {
+    if (!this.adhocFiltersDataset) {
+      return [];
+    }
+
+    return this.getResource(`datasets/${this.adhocFiltersDataset}/tag-values`, { key: options.key });
+  }
+
+  async metricFindQuery(query: MyQuery | string, options?: { scopedVars?: ScopedVars }): Promise<MetricFindValue[]> {
+    const q = typeof query === 'string' ? { queryText: query } : query;
+
+    return this.postResource('variables', {
+      queryText: getTemplateSrv().replace(q.queryText ?? '', options?.scopedVars),
+      querySource: (q as MyQuery).querySource ?? 'default',
+    });
+  }
 }
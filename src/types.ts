@@ -2,24 +2,64 @@ import { DataQuery, DataSourceJsonData } from '@grafana/data';
 
 export type QuerySource = 'default' | 'firecache';
 
+export type QueryFormat = 'table' | 'numeric';
+
+export interface AdhocFilter {
+  key: string;
+  operator: string;
+  value: string;
+}
+
 export interface MyQuery extends DataQuery {
   querySource?: QuerySource;
   queryText?: string;
+  adhocFilters?: AdhocFilter[];
+  timeColumn?: string;
+  format?: QueryFormat;
+  live?: boolean;
+  liveInterval?: string;
+  stream?: boolean;
 }
 
+export const ANNOTATION_QUERY_TYPE = 'annotation';
+
 export const DEFAULT_QUERY: Partial<MyQuery> = {
   querySource: 'default',
   queryText: 'SELECT * FROM eth.recent_blocks LIMIT 10',
 };
 
+export type AuditSink = '' | 'stdout' | 'file' | 'webhook';
+
 /**
  * These are options configured for each DataSource instance
  */
-export interface MyDataSourceOptions extends DataSourceJsonData {}
+export interface MyDataSourceOptions extends DataSourceJsonData {
+  healthQuery?: string;
+  flightAddress?: string;
+  firecacheAddress?: string;
+  datasetsUrl?: string;
+  tlsAuth?: boolean;
+  tlsAuthWithCACert?: boolean;
+  tlsSkipVerify?: boolean;
+  serverName?: string;
+  adhocFiltersDataset?: string;
+  slowQueryThreshold?: string;
+  auditSink?: AuditSink;
+  auditPath?: string;
+  auditWebhookUrl?: string;
+  auditSampleRate?: number;
+  oauthPassThru?: boolean;
+  userHeader?: string;
+  userHeaderValue?: 'login' | 'email';
+}
 
 /**
  * Value that is used in the backend, but never sent over HTTP to the frontend
  */
 export interface MySecureJsonData {
   apiKey?: string;
+  secondaryApiKey?: string;
+  tlsCACert?: string;
+  tlsClientCert?: string;
+  tlsClientKey?: string;
 }